@@ -21,16 +21,60 @@ document.getElementById('login-form').addEventListener('submit', async (event) =
   await login();
 });
 
+// authFetch is fetch with the access token attached. Access tokens are
+// short-lived, so on a 401 it trades the refresh token for a new pair and
+// retries once.
+async function authFetch(url, options = {}) {
+  const withToken = () => ({
+    ...options,
+    headers: { ...options.headers, Authorization: `Bearer ${localStorage.getItem('token')}` },
+  });
+
+  const res = await fetch(url, withToken());
+  if (res.status !== 401 || !(await refreshSession())) {
+    return res;
+  }
+  return fetch(url, withToken());
+}
+
+let refreshing = null;
+
+async function refreshSession() {
+  const refreshToken = localStorage.getItem('refreshToken');
+  if (!refreshToken) {
+    return false;
+  }
+  // Concurrent requests share one refresh; rotating twice would look like reuse.
+  if (!refreshing) {
+    refreshing = fetch('/api/refresh', {
+      method: 'POST',
+      headers: { Authorization: `Bearer ${refreshToken}` },
+    })
+      .then(async (res) => {
+        if (!res.ok) {
+          return false;
+        }
+        const data = await res.json();
+        localStorage.setItem('token', data.token);
+        localStorage.setItem('refreshToken', data.refresh_token);
+        return true;
+      })
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
 async function createVideoDraft() {
   const title = document.getElementById('video-title').value;
   const description = document.getElementById('video-description').value;
 
   try {
-    const res = await fetch('/api/videos', {
+    const res = await authFetch('/api/videos', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
       },
       body: JSON.stringify({ title, description }),
     });
@@ -68,6 +112,7 @@ async function login() {
 
     if (data.token) {
       localStorage.setItem('token', data.token);
+      localStorage.setItem('refreshToken', data.refresh_token);
       document.getElementById('auth-section').style.display = 'none';
       document.getElementById('video-section').style.display = 'block';
       await getVideos();
@@ -103,7 +148,15 @@ async function signup() {
 }
 
 function logout() {
+  const refreshToken = localStorage.getItem('refreshToken');
+  if (refreshToken) {
+    fetch('/api/revoke', {
+      method: 'POST',
+      headers: { Authorization: `Bearer ${refreshToken}` },
+    });
+  }
   localStorage.removeItem('token');
+  localStorage.removeItem('refreshToken');
   document.getElementById('auth-section').style.display = 'block';
   document.getElementById('video-section').style.display = 'none';
 }
@@ -130,11 +183,8 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+    const res = await authFetch(`/api/thumbnail_upload/${videoID}`, {
       method: 'POST',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
       body: formData,
     });
     if (!res.ok) {
@@ -163,11 +213,8 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/video_upload/${videoID}`, {
+    const res = await authFetch(`/api/video_upload/${videoID}`, {
       method: 'POST',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
       body: formData,
     });
     if (!res.ok) {
@@ -177,6 +224,7 @@ async function uploadVideoFile(videoID) {
 
     console.log('Video uploaded!');
     await getVideo(videoID);
+    await waitForProcessing(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
@@ -184,30 +232,54 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+async function waitForProcessing(videoID) {
+  while (currentVideo && currentVideo.id === videoID) {
+    const state = currentVideo.processing_state;
+    if (state !== 'uploaded' && state !== 'processing') {
+      if (state === 'failed') {
+        throw new Error(`Video processing failed: ${currentVideo.processing_error}`);
+      }
+      return;
+    }
+    await new Promise((resolve) => setTimeout(resolve, 3000));
+    await getVideo(videoID);
+  }
+}
+
 const videoStateHandler = createVideoStateHandler();
 
-async function getVideos() {
+let nextVideosCursor = null;
+
+// getVideos loads the first page of videos, or appends the page after cursor.
+async function getVideos(cursor) {
+  const params = new URLSearchParams();
+  if (cursor) {
+    params.set('cursor', cursor);
+  }
+
   try {
-    const res = await fetch('/api/videos', {
+    const res = await authFetch(`/api/videos?${params}`, {
       method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
     });
     if (!res.ok) {
       const data = await res.json();
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const { videos, next_cursor } = await res.json();
     const videoList = document.getElementById('video-list');
-    videoList.innerHTML = '';
+    if (!cursor) {
+      videoList.innerHTML = '';
+    }
     for (const video of videos) {
       const listItem = document.createElement('li');
       listItem.textContent = video.title;
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
+
+    nextVideosCursor = next_cursor;
+    document.getElementById('load-more-videos').style.display = next_cursor ? 'inline-block' : 'none';
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
@@ -231,11 +303,8 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
   try {
-    const res = await fetch(`/api/videos/${videoID}`, {
+    const res = await authFetch(`/api/videos/${videoID}`, {
       method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
     });
     if (!res.ok) {
       throw new Error('Failed to get video.');
@@ -255,6 +324,7 @@ function viewVideo(video) {
   document.getElementById('video-display').style.display = 'block';
   document.getElementById('video-title-display').textContent = video.title;
   document.getElementById('video-description-display').textContent = video.description;
+  document.getElementById('video-metadata-display').textContent = formatMetadata(video.metadata);
 
   const thumbnailImg = document.getElementById('thumbnail-image');
   if (!video.thumbnail_url) {
@@ -276,6 +346,16 @@ function viewVideo(video) {
   }
 }
 
+function formatMetadata(metadata) {
+  if (!metadata || !metadata.duration_seconds) {
+    return '';
+  }
+  const total = Math.round(metadata.duration_seconds);
+  const minutes = Math.floor(total / 60);
+  const seconds = String(total % 60).padStart(2, '0');
+  return `${minutes}:${seconds} · ${metadata.width}x${metadata.height} · ${metadata.video_codec}`;
+}
+
 async function deleteVideo() {
   if (!currentVideo) {
     alert('No video selected for deletion.');
@@ -283,11 +363,8 @@ async function deleteVideo() {
   }
 
   try {
-    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+    const res = await authFetch(`/api/videos/${currentVideo.id}`, {
       method: 'DELETE',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
     });
     if (!res.ok) {
       throw new Error('Failed to delete video.');
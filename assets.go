@@ -1,12 +1,66 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 )
 
+// newBlobStore opens the storage backend media is kept in: "s3", or "local",
+// which keeps files under assetsRoot/assets.
+func newBlobStore(ctx context.Context, backend, s3Bucket, s3Region, assetsRoot, port string) (storage.BlobStore, error) {
+	switch backend {
+	case "s3":
+		awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(s3Region))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load AWS config: %w", err)
+		}
+		return storage.NewS3Store(s3.NewFromConfig(awsCfg), s3Bucket), nil
+	case "local":
+		localStore, err := storage.NewLocalStore(
+			filepath.Join(assetsRoot, "assets"),
+			fmt.Sprintf("http://localhost:%s/assets", port),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return localStore, nil
+	}
+	return nil, fmt.Errorf("unknown STORAGE_BACKEND %q, expected \"s3\" or \"local\"", backend)
+}
+
 func (cfg apiConfig) ensureAssetsDir() error {
 	if _, err := os.Stat(cfg.assetsRoot); os.IsNotExist(err) {
 		return os.Mkdir(cfg.assetsRoot, 0755)
 	}
 	return nil
 }
+
+// putDirectory stores every file below dir under keyPrefix, keeping the
+// relative layout of the directory.
+func (cfg apiConfig) putDirectory(ctx context.Context, dir, keyPrefix string, contentType func(string) string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		key := path.Join(keyPrefix, filepath.ToSlash(rel))
+		return cfg.store.Put(ctx, key, f, contentType(p))
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Uploads are hashed with SHA-256 as they're written, and processed media is
+// stored once per hash as a blob that every video with the same upload uses.
+
+// uploadHash is the running hash of a resumable upload, carried from one
+// chunk to the next in the upload's hash state.
+type uploadHash struct {
+	hash.Hash
+	// n is how many bytes were hashed since the hash was resumed.
+	n int64
+}
+
+func (h *uploadHash) Write(p []byte) (int, error) {
+	n, err := h.Hash.Write(p)
+	h.n += int64(n)
+	return n, err
+}
+
+// resumeUploadHash continues hashing an upload at offset from the state saved
+// with it. It returns nil when the state was lost, leaving the hash to be
+// worked out from the whole file.
+func resumeUploadHash(state string, offset int64) *uploadHash {
+	h := sha256.New()
+	if offset == 0 {
+		return &uploadHash{Hash: h}
+	}
+	if state == "" {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(state)
+	if err != nil {
+		return nil
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(b); err != nil {
+		return nil
+	}
+	return &uploadHash{Hash: h}
+}
+
+// state returns the hash state to save with an upload that grew by written
+// bytes, or "" when some of them didn't make it into the hash.
+func (h *uploadHash) state(written int64) string {
+	if h == nil || h.n != written {
+		return ""
+	}
+	b, err := h.Hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// sum returns the hex encoded hash of everything written.
+func (h *uploadHash) sum() string {
+	return hex.EncodeToString(h.Hash.Sum(nil))
+}
+
+// hashFile returns the hex encoded SHA-256 of the file at filePath.
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("couldn't hash %s: %w", filePath, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// blobVideo is a video made only of a blob's media, for listing its objects.
+func blobVideo(blob database.Blob) database.Video {
+	loc := func(key string) *database.StorageLocation {
+		if key == "" {
+			return nil
+		}
+		return &database.StorageLocation{Backend: blob.Backend, Bucket: blob.Bucket, Key: key}
+	}
+	return database.Video{
+		VideoLocation:     loc(blob.VideoKey),
+		HLSLocation:       loc(blob.HLSKey),
+		ThumbnailLocation: loc(blob.ThumbnailKey),
+	}
+}
+
+// unusedMediaKeys lists the objects that held old's media once the video has
+// moved off its blob, which released is the result of. Processed media still
+// shared with other videos is left out.
+func (cfg *apiConfig) unusedMediaKeys(ctx context.Context, old database.Video, released *database.Blob) ([]string, error) {
+	if old.BlobSHA256 != "" && released == nil {
+		// Only a thumbnail of the video's own is left to go, not one the
+		// blob's processing generated
+		if old.ThumbnailLocation != nil && old.VideoLocation != nil &&
+			strings.HasPrefix(old.ThumbnailLocation.Key, processedMediaPrefix(old.VideoLocation.Key)) {
+			old.ThumbnailLocation = nil
+		}
+		old.VideoLocation = nil
+		old.HLSLocation = nil
+	}
+	return cfg.videoObjectKeys(ctx, old)
+}
@@ -1,10 +0,0 @@
-package main
-
-import "net/http"
-
-func noCacheMiddleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Cache-Control", "no-store")
-        next.ServeHTTP(w, r)
-    })
-}
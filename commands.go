@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const commandUsage = `usage:
+  tubely                        start the server
+  tubely migrate status         list migrations and whether they are applied
+  tubely migrate up [n]         apply the next n pending migrations (default: all)
+  tubely migrate down [n]       revert the last n applied migrations (default: 1)
+  tubely user role EMAIL ROLE   set a user's role: admin, creator or viewer
+  tubely gc [--dry-run]         queue stored objects nothing refers to for deletion
+                                and delete the ones past their grace period
+  tubely gc status              list objects waiting to be deleted`
+
+// runCommand handles the administrative subcommands that run instead of the
+// server.
+func runCommand(dbPath string, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(dbPath, args[1:])
+	case "user":
+		return runUserCommand(dbPath, args[1:])
+	case "gc":
+		return runGCCommand(dbPath, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandUsage)
+	}
+}
+
+func runMigrateCommand(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(commandUsage)
+	}
+
+	steps := 0
+	if args[0] == "down" {
+		steps = 1
+	}
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of steps %q", args[1])
+		}
+		steps = n
+	}
+
+	db, err := database.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.NeedsFTS5 && !db.FullTextSearch() {
+				state = "pending (needs -tags sqlite_fts5)"
+			}
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-40s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+	case "up":
+		applied, err := db.MigrateUp(steps)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := db.MigrateDown(steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], commandUsage)
+	}
+}
+
+// runUserCommand manages users from the command line, which is how the first
+// admin is appointed.
+func runUserCommand(dbPath string, args []string) error {
+	if len(args) != 3 || args[0] != "role" {
+		return errors.New(commandUsage)
+	}
+	email := args[1]
+	role, err := auth.ParseRole(args[2])
+	if err != nil {
+		return err
+	}
+
+	db, err := database.NewClient(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("no user with email %q", email)
+	}
+	err = db.SetUserRole(user.ID, string(role))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", email, role)
+	return nil
+}
+
+// runGCCommand collects garbage in the configured store, for deployments that
+// leave it to a scheduled job rather than the server.
+func runGCCommand(dbPath string, args []string) error {
+	dryRun := false
+	status := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "--dry-run":
+		dryRun = true
+	case len(args) == 1 && args[0] == "status":
+		status = true
+	default:
+		return errors.New(commandUsage)
+	}
+
+	gcOpts, err := gcOptionsFromEnv()
+	if err != nil {
+		return err
+	}
+	dryRun = dryRun || gcOpts.DryRun
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
+	}
+	ctx := context.Background()
+	store, err := newBlobStore(ctx, storageBackend, os.Getenv("S3_BUCKET"), os.Getenv("S3_REGION"), os.Getenv("ASSETS_ROOT"), os.Getenv("PORT"))
+	if err != nil {
+		return err
+	}
+	db, err := database.NewClient(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cfg := &apiConfig{
+		db:             &db,
+		s3Bucket:       os.Getenv("S3_BUCKET"),
+		storageBackend: storageBackend,
+		store:          store,
+		gcOpts:         gcOpts,
+	}
+
+	if status {
+		loc := cfg.storageLocation("")
+		pending, err := db.ListPendingDeletions(loc.Backend, loc.Bucket, false, time.Now(), 1000)
+		if err != nil {
+			return err
+		}
+		for _, d := range pending {
+			lastError := ""
+			if d.LastError != nil {
+				lastError = *d.LastError
+			}
+			fmt.Printf("%s  %-60s attempts=%d %s\n", d.DeleteAfter.Local().Format("2006-01-02 15:04:05"), d.Key, d.Attempts, lastError)
+		}
+		fmt.Printf("%d objects waiting to be deleted\n", len(pending))
+		return nil
+	}
+
+	report, err := cfg.collectGarbage(ctx, dryRun)
+	for _, obj := range report.Orphans {
+		fmt.Printf("orphan %-60s %10d bytes, modified %s\n", obj.Key, obj.Size, obj.LastModified.Local().Format("2006-01-02 15:04:05"))
+	}
+	if dryRun {
+		fmt.Printf("%d orphaned objects\n", len(report.Orphans))
+		return err
+	}
+	fmt.Printf("%d orphaned objects queued, %d deleted, %d failed, %d back in use\n", len(report.Orphans), report.Deleted, report.Failed, report.Reclaimed)
+	return err
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	// gcBatchSize is how many pending deletions are read at a time.
+	gcBatchSize = 100
+	// gcRetryBase is how long a failed deletion waits before its first
+	// retry. Each later retry waits twice as long, up to gcRetryMax.
+	gcRetryBase = time.Minute
+	gcRetryMax  = 6 * time.Hour
+)
+
+// gcPrefixes are the prefixes the app stores objects under: processed media
+// by aspect ratio, direct uploads and uploaded thumbnails. Garbage collection
+// never looks outside them, so the bucket can be shared with other things.
+var gcPrefixes = []string{"landscape/", "portrait/", "other/", "uploads/", thumbnailKeyPrefix}
+
+// gcOptions control the garbage collector that deletes stored objects
+// nothing refers to anymore.
+type gcOptions struct {
+	// GracePeriod is how long an object is kept after it stops being used,
+	// so URLs already handed out for it keep working. Unreferenced objects
+	// younger than this, such as uploads still in progress, aren't touched.
+	GracePeriod time.Duration
+	// Interval is how often the server collects garbage. Zero, the
+	// default, leaves it to `tubely gc`.
+	Interval time.Duration
+	// DryRun only logs the objects that would be deleted.
+	DryRun bool
+}
+
+func gcOptionsFromEnv() (gcOptions, error) {
+	opts := gcOptions{
+		GracePeriod: 24 * time.Hour,
+	}
+	if v := os.Getenv("GC_GRACE_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return gcOptions{}, fmt.Errorf("invalid GC_GRACE_PERIOD %q", v)
+		}
+		opts.GracePeriod = d
+	}
+	if v := os.Getenv("GC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return gcOptions{}, fmt.Errorf("invalid GC_INTERVAL %q", v)
+		}
+		opts.Interval = d
+	}
+	if v := os.Getenv("GC_DRY_RUN"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return gcOptions{}, fmt.Errorf("invalid GC_DRY_RUN %q", v)
+		}
+		opts.DryRun = dryRun
+	}
+	return opts, nil
+}
+
+// processedMediaPrefix is the prefix under which video processing stores the
+// HLS renditions and thumbnails that go with the MP4 at videoKey.
+func processedMediaPrefix(videoKey string) string {
+	return strings.TrimSuffix(videoKey, path.Ext(videoKey)) + "/"
+}
+
+// videoObjectKeys lists the objects in the configured store that hold a
+// video's media.
+func (cfg *apiConfig) videoObjectKeys(ctx context.Context, video database.Video) ([]string, error) {
+	keys := []string{}
+	prefixes := []string{}
+	if loc := video.ThumbnailLocation; loc != nil && cfg.inConfiguredStore(*loc) {
+		keys = append(keys, loc.Key)
+	}
+	if loc := video.VideoLocation; loc != nil && cfg.inConfiguredStore(*loc) {
+		keys = append(keys, loc.Key)
+		prefixes = append(prefixes, processedMediaPrefix(loc.Key))
+	}
+	if loc := video.HLSLocation; loc != nil && cfg.inConfiguredStore(*loc) {
+		prefixes = append(prefixes, path.Dir(loc.Key)+"/")
+	}
+
+	for _, prefix := range prefixes {
+		objects, err := cfg.store.List(ctx, prefix)
+		if err != nil {
+			return keys, fmt.Errorf("couldn't list objects under %s: %w", prefix, err)
+		}
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+	}
+	return keys, nil
+}
+
+// discardObjects queues objects in the configured store for deletion once
+// the grace period has passed.
+func (cfg *apiConfig) discardObjects(keys []string) error {
+	deleteAfter := time.Now().Add(cfg.gcOpts.GracePeriod)
+	for _, key := range keys {
+		err := cfg.db.QueueDeletion(database.QueueDeletionParams{
+			StorageLocation: *cfg.storageLocation(key),
+			DeleteAfter:     deleteAfter,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteObjects deletes objects from the configured store right away. The
+// ones that can't be deleted are queued to be retried.
+func (cfg *apiConfig) deleteObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		log.Printf("Deleting stored object with key: %s", key)
+		err := cfg.store.Delete(ctx, key)
+		if err == nil {
+			continue
+		}
+		log.Printf("Failed to delete stored object %s, will retry: %v", key, err)
+		err = cfg.db.QueueDeletion(database.QueueDeletionParams{
+			StorageLocation: *cfg.storageLocation(key),
+			DeleteAfter:     time.Now().Add(gcRetryBase),
+		})
+		if err != nil {
+			log.Printf("Couldn't queue %s for deletion: %v", key, err)
+		}
+	}
+}
+
+// storageRefs is everything in the configured store that is in use: single
+// objects, and every object under a prefix.
+type storageRefs struct {
+	keys     map[string]bool
+	prefixes []string
+}
+
+func (refs storageRefs) contains(key string) bool {
+	if refs.keys[key] {
+		return true
+	}
+	for _, prefix := range refs.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) loadStorageRefs() (storageRefs, error) {
+	loc := cfg.storageLocation("")
+	dbRefs, err := cfg.db.ListStorageReferences(loc.Backend, loc.Bucket)
+	if err != nil {
+		return storageRefs{}, err
+	}
+
+	refs := storageRefs{keys: map[string]bool{}}
+	for _, ref := range dbRefs {
+		refs.keys[ref.Key] = true
+		switch ref.Asset {
+		case "video":
+			refs.prefixes = append(refs.prefixes, processedMediaPrefix(ref.Key))
+		case "hls":
+			refs.prefixes = append(refs.prefixes, path.Dir(ref.Key)+"/")
+		}
+	}
+	return refs, nil
+}
+
+// gcReport sums up one garbage collection.
+type gcReport struct {
+	// Orphans are the objects found that nothing refers to.
+	Orphans []storage.ObjectInfo
+	// Deleted objects were due and are gone; Failed ones will be retried.
+	Deleted int
+	Failed  int
+	// Reclaimed deletions were cancelled because the object is in use again.
+	Reclaimed int
+}
+
+// collectGarbage reconciles the configured store against the database:
+// objects nothing refers to are queued for deletion after the grace period,
+// and queued deletions that are due are carried out. With dryRun set,
+// orphans are only reported.
+func (cfg *apiConfig) collectGarbage(ctx context.Context, dryRun bool) (gcReport, error) {
+	report := gcReport{Orphans: []storage.ObjectInfo{}}
+	settled := time.Now().Add(-cfg.gcOpts.GracePeriod)
+	if !dryRun {
+		err := cfg.discardUnusedBlobs(ctx, settled)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	refs, err := cfg.loadStorageRefs()
+	if err != nil {
+		return report, fmt.Errorf("couldn't load storage references: %w", err)
+	}
+
+	for _, prefix := range gcPrefixes {
+		objects, err := cfg.store.List(ctx, prefix)
+		if err != nil {
+			return report, fmt.Errorf("couldn't list stored objects under %s: %w", prefix, err)
+		}
+		for _, obj := range objects {
+			if refs.contains(obj.Key) || obj.LastModified.After(settled) {
+				continue
+			}
+			report.Orphans = append(report.Orphans, obj)
+		}
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	keys := make([]string, 0, len(report.Orphans))
+	for _, obj := range report.Orphans {
+		keys = append(keys, obj.Key)
+	}
+	err = cfg.discardObjects(keys)
+	if err != nil {
+		return report, fmt.Errorf("couldn't queue orphans for deletion: %w", err)
+	}
+
+	err = cfg.processPendingDeletions(ctx, refs, &report)
+	return report, err
+}
+
+// discardUnusedBlobs forgets blobs created before the given time that no
+// video took up, and queues their objects for deletion.
+func (cfg *apiConfig) discardUnusedBlobs(ctx context.Context, before time.Time) error {
+	blobs, err := cfg.db.DeleteUnusedBlobs(before)
+	if err != nil {
+		return fmt.Errorf("couldn't delete unused blobs: %w", err)
+	}
+	for _, blob := range blobs {
+		keys, err := cfg.videoObjectKeys(ctx, blobVideo(blob))
+		if err != nil {
+			log.Printf("Couldn't find every object of blob %s, the rest is left to garbage collection: %v", blob.SHA256, err)
+		}
+		err = cfg.discardObjects(keys)
+		if err != nil {
+			return fmt.Errorf("couldn't queue objects of blob %s for deletion: %w", blob.SHA256, err)
+		}
+	}
+	return nil
+}
+
+// processPendingDeletions deletes the queued objects that are due, unless
+// they have come back into use.
+func (cfg *apiConfig) processPendingDeletions(ctx context.Context, refs storageRefs, report *gcReport) error {
+	loc := cfg.storageLocation("")
+	for {
+		now := time.Now()
+		due, err := cfg.db.ListPendingDeletions(loc.Backend, loc.Bucket, true, now, gcBatchSize)
+		if err != nil {
+			return fmt.Errorf("couldn't list pending deletions: %w", err)
+		}
+
+		for _, d := range due {
+			if refs.contains(d.Key) {
+				report.Reclaimed++
+				err = cfg.db.RemovePendingDeletion(d.ID)
+			} else if deleteErr := cfg.store.Delete(ctx, d.Key); deleteErr != nil {
+				report.Failed++
+				log.Printf("Failed to delete stored object %s: %v", d.Key, deleteErr)
+				err = cfg.db.FailPendingDeletion(d.ID, deleteErr, now.Add(gcRetryDelay(d.Attempts+1)))
+			} else {
+				report.Deleted++
+				err = cfg.db.RemovePendingDeletion(d.ID)
+			}
+			if err != nil {
+				return fmt.Errorf("couldn't update pending deletion of %s: %w", d.Key, err)
+			}
+		}
+
+		// Failed deletions are pushed into the future, so each batch only
+		// holds objects not seen yet
+		if len(due) < gcBatchSize {
+			return nil
+		}
+	}
+}
+
+// gcRetryDelay is how long to wait before retrying a deletion that has
+// failed attempts times.
+func gcRetryDelay(attempts int) time.Duration {
+	delay := gcRetryBase
+	for i := 1; i < attempts && delay < gcRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, gcRetryMax)
+}
+
+// startGarbageCollector collects garbage every gcOpts.Interval until ctx is
+// done.
+func (cfg *apiConfig) startGarbageCollector(ctx context.Context) {
+	if cfg.gcOpts.Interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.gcOpts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			report, err := cfg.collectGarbage(ctx, cfg.gcOpts.DryRun)
+			if err != nil {
+				log.Printf("Garbage collection failed: %v", err)
+				continue
+			}
+			if cfg.gcOpts.DryRun {
+				for _, obj := range report.Orphans {
+					log.Printf("Garbage collection dry run: would delete %s (%d bytes, modified %s)", obj.Key, obj.Size, obj.LastModified.Format(time.RFC3339))
+				}
+				continue
+			}
+			if len(report.Orphans) > 0 || report.Deleted > 0 || report.Failed > 0 {
+				log.Printf("Garbage collection: %d orphans queued, %d objects deleted, %d failed", len(report.Orphans), report.Deleted, report.Failed)
+			}
+		}
+	}()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// gcFixture stores a video with its processed media, orphans old and new
+// inside the prefixes garbage collection looks at, and an old object
+// outside them. It returns the directory the local store keeps objects in.
+func gcFixture(t *testing.T, cfg *apiConfig) string {
+	t.Helper()
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := storage.NewLocalStore(root, "http://localhost/assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.store = store
+
+	p := createTestPrincipal(t, cfg, "owner@example.com", auth.RoleCreator)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "kept", UserID: p.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video.VideoLocation = cfg.storageLocation("landscape/kept.mp4")
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	objects := map[string]time.Time{
+		"landscape/kept.mp4":            old,
+		"landscape/kept/hls/index.m3u8": old,
+		"portrait/orphan.mp4":           old,
+		"thumbnails/orphan.png":         old,
+		"other/fresh.mp4":               time.Now(),
+		"elsewhere/old.txt":             old,
+	}
+	for key, modified := range objects {
+		err := cfg.store.Put(ctx, key, strings.NewReader(key), "application/octet-stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(filepath.Join(root, filepath.FromSlash(key)), modified, modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func listPendingDeletions(t *testing.T, cfg *apiConfig) []string {
+	t.Helper()
+	loc := cfg.storageLocation("")
+	deletions, err := cfg.db.ListPendingDeletions(loc.Backend, loc.Bucket, false, time.Now(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, d := range deletions {
+		keys = append(keys, d.Key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestCollectGarbageQueuesOrphans(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.gcOpts.GracePeriod = time.Hour
+	root := gcFixture(t, cfg)
+	wantOrphans := []string{"portrait/orphan.mp4", "thumbnails/orphan.png"}
+
+	report, err := cfg.collectGarbage(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans := []string{}
+	for _, obj := range report.Orphans {
+		orphans = append(orphans, obj.Key)
+	}
+	slices.Sort(orphans)
+	if !slices.Equal(orphans, wantOrphans) {
+		t.Fatalf("dry run: got orphans %v, want %v", orphans, wantOrphans)
+	}
+	if queued := listPendingDeletions(t, cfg); len(queued) != 0 {
+		t.Fatalf("a dry run shouldn't queue anything, queued %v", queued)
+	}
+
+	report, err = cfg.collectGarbage(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != len(wantOrphans) || report.Deleted != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if queued := listPendingDeletions(t, cfg); !slices.Equal(queued, wantOrphans) {
+		t.Fatalf("got queued %v, want %v", queued, wantOrphans)
+	}
+	// Queued objects outlive the grace period
+	for _, key := range wantOrphans {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(key))); err != nil {
+			t.Errorf("%s was deleted before its grace period ran out", key)
+		}
+	}
+}
+
+func TestCollectGarbageDeletesOrphans(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.gcOpts.GracePeriod = 0
+	root := gcFixture(t, cfg)
+
+	// The video still uses an object queued for deletion earlier
+	err := cfg.db.QueueDeletion(database.QueueDeletionParams{
+		StorageLocation: *cfg.storageLocation("landscape/kept.mp4"),
+		DeleteAfter:     time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := cfg.collectGarbage(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 3 || report.Failed != 0 || report.Reclaimed != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	for key, kept := range map[string]bool{
+		"landscape/kept.mp4":            true,
+		"landscape/kept/hls/index.m3u8": true,
+		"elsewhere/old.txt":             true,
+		"portrait/orphan.mp4":           false,
+		"thumbnails/orphan.png":         false,
+		"other/fresh.mp4":               false,
+	} {
+		_, err := os.Stat(filepath.Join(root, filepath.FromSlash(key)))
+		if kept && err != nil {
+			t.Errorf("%s should have been kept: %v", key, err)
+		}
+		if !kept && !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", key)
+		}
+	}
+	if queued := listPendingDeletions(t, cfg); len(queued) != 0 {
+		t.Fatalf("nothing should be left queued, got %v", queued)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var errUserGone = errors.New("user no longer exists")
+
+// lookupUserRole resolves the current role of an authenticated user for
+// auth.Authenticator. Credentials of deleted users stop working with it.
+func (cfg *apiConfig) lookupUserRole(ctx context.Context, userID uuid.UUID) (auth.Role, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", errUserGone
+	}
+	return auth.ParseRole(user.Role)
+}
+
+// requirePolicy only lets through callers that check allows. It must run
+// after the auth middleware.
+func requirePolicy(check func(auth.Principal) error) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+				return
+			}
+			err := check(principal)
+			if err != nil {
+				respondWithError(w, http.StatusForbidden, "You don't have permission to do this", err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list users", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// getUserParam loads the user named by the userID URL param.
+func (cfg *apiConfig) getUserParam(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return database.User{}, false
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return database.User{}, false
+	}
+	return *user, true
+}
+
+func (cfg *apiConfig) handlerAdminUserVideos(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.getUserParam(w, r)
+	if !ok {
+		return
+	}
+	cfg.respondWithVideoPage(w, r, user.ID)
+}
+
+func (cfg *apiConfig) handlerAdminUserSetRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	// Only a logged-in admin can hand out roles; a leaked API key shouldn't
+	// be able to mint admins.
+	principal, ok := getSessionPrincipal(w, r)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getUserParam(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.SetUserRole(user.ID, string(role))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	updated, err := cfg.db.GetUser(user.ID)
+	if err != nil || updated == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+func (cfg *apiConfig) handlerAdminUserUsage(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.getUserParam(w, r)
+	if !ok {
+		return
+	}
+	usage, err := cfg.getStorageUsage(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, usage)
+}
+
+// handlerAdminUserSetQuota overrides a user's storage quotas. A quota left
+// out or null goes back to the default, and 0 lifts the limit.
+func (cfg *apiConfig) handlerAdminUserSetQuota(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		QuotaBytes  *int64 `json:"quota_bytes"`
+		QuotaVideos *int64 `json:"quota_videos"`
+	}
+
+	// Like roles, quotas are only changed by a logged-in admin, not with an
+	// API key
+	if _, ok := getSessionPrincipal(w, r); !ok {
+		return
+	}
+	user, ok := cfg.getUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if (params.QuotaBytes != nil && *params.QuotaBytes < 0) || (params.QuotaVideos != nil && *params.QuotaVideos < 0) {
+		respondWithError(w, http.StatusBadRequest, "Quotas can't be negative", nil)
+		return
+	}
+
+	err = cfg.db.SetUserQuota(user.ID, params.QuotaBytes, params.QuotaVideos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update quota", err)
+		return
+	}
+	usage, err := cfg.getStorageUsage(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, usage)
+}
+
+// handlerAdminUserDelete deletes a user along with all of their videos and
+// the media stored for them.
+func (cfg *apiConfig) handlerAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+	user, ok := cfg.getUserParam(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't delete your own account", nil)
+		return
+	}
+
+	for {
+		videos, _, err := cfg.db.ListVideos(database.ListVideosParams{
+			UserID: user.ID,
+			Limit:  maxVideosPageSize,
+			Sort:   database.VideoSortCreated,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't list videos", err)
+			return
+		}
+		if len(videos) == 0 {
+			break
+		}
+		for _, video := range videos {
+			err := cfg.deleteVideo(r.Context(), video)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+				return
+			}
+		}
+	}
+
+	err := cfg.db.DeleteUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	maxAPIKeyNameLength = 100
+	// apiKeyDisplayLength is how much of a key is kept in the clear, enough
+	// to get past the "tubely_" marker and tell keys apart.
+	apiKeyDisplayLength = 15
+)
+
+var errInvalidAPIKey = errors.New("invalid or revoked API key")
+
+// authenticateAPIKey resolves an API key for auth.Authenticator.
+func (cfg *apiConfig) authenticateAPIKey(ctx context.Context, key string) (auth.Principal, error) {
+	apiKey, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(key))
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	if apiKey.ID == uuid.Nil || apiKey.RevokedAt != nil {
+		return auth.Principal{}, errInvalidAPIKey
+	}
+	scopes, err := auth.ParseScopes(apiKey.Scopes)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	err = cfg.db.TouchAPIKey(apiKey.ID)
+	if err != nil {
+		log.Printf("Couldn't record use of API key %s: %v", apiKey.ID, err)
+	}
+	return auth.Principal{UserID: apiKey.UserID, Scopes: scopes, APIKeyID: apiKey.ID}, nil
+}
+
+// getSessionPrincipal returns the caller if they logged in with a password.
+// Managing API keys with an API key would let a leaked key mint more.
+func getSessionPrincipal(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return auth.Principal{}, false
+	}
+	if principal.IsAPIKey() {
+		respondWithError(w, http.StatusForbidden, "API keys can only be managed from a logged-in session", nil)
+		return auth.Principal{}, false
+	}
+	return principal, true
+}
+
+func (cfg *apiConfig) handlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	type response struct {
+		database.APIKey
+		// Key is only ever shown in this response.
+		Key string `json:"key"`
+	}
+
+	principal, ok := getSessionPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Name == "" || len(params.Name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name is required and must be at most 100 characters", nil)
+		return
+	}
+	scopes, err := auth.ParseScopes(params.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required", nil)
+		return
+	}
+	scopeNames := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scopeNames = append(scopeNames, string(scope))
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate API key", err)
+		return
+	}
+
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:  principal.UserID,
+		Name:    params.Name,
+		Prefix:  key[:apiKeyDisplayLength],
+		KeyHash: auth.HashAPIKey(key),
+		Scopes:  scopeNames,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{APIKey: apiKey, Key: key})
+}
+
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	principal, ok := getSessionPrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := cfg.db.ListAPIKeys(principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// getOwnedAPIKey parses the keyID URL param and checks that the caller owns
+// the key.
+func (cfg *apiConfig) getOwnedAPIKey(w http.ResponseWriter, r *http.Request) (database.APIKey, bool) {
+	principal, ok := getSessionPrincipal(w, r)
+	if !ok {
+		return database.APIKey{}, false
+	}
+
+	keyID, err := uuid.Parse(chi.URLParam(r, "keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return database.APIKey{}, false
+	}
+
+	apiKey, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return database.APIKey{}, false
+	}
+	if apiKey.ID == uuid.Nil || apiKey.UserID != principal.UserID {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return database.APIKey{}, false
+	}
+	return apiKey, true
+}
+
+func (cfg *apiConfig) handlerAPIKeysRename(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	apiKey, ok := cfg.getOwnedAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Name == "" || len(params.Name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name is required and must be at most 100 characters", nil)
+		return
+	}
+
+	err = cfg.db.RenameAPIKey(apiKey.ID, params.Name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rename API key", err)
+		return
+	}
+	apiKey, err = cfg.db.GetAPIKey(apiKey.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, apiKey)
+}
+
+func (cfg *apiConfig) handlerAPIKeysRevoke(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := cfg.getOwnedAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.RevokeAPIKey(apiKey.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/policy"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	directUploadMaxSize     = 5 << 30
+	directUploadPartSize    = 64 << 20
+	directUploadMultipartAt = 100 << 20
+	directUploadURLExpiry   = time.Hour
+)
+
+// directUploadPrefix is where browsers stage uploads for a video before the
+// completion callback hands them to the processing pipeline.
+func directUploadPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("uploads/%s/", videoID)
+}
+
+// getEditableVideoForUpload parses the videoID URL param and checks that the
+// caller may edit the video.
+func (cfg *apiConfig) getEditableVideoForUpload(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(chi.URLParam(r, "videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return database.Video{}, false
+	}
+	if !cfg.authorizeVideo(w, principal, video, policy.CanEditVideo, "You can't edit this video") {
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) handlerDirectUploadCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Size        int64  `json:"size"`
+		ContentType string `json:"content_type"`
+		Multipart   bool   `json:"multipart"`
+	}
+	type uploadPart struct {
+		PartNumber int32  `json:"part_number"`
+		URL        string `json:"url"`
+	}
+	type response struct {
+		Key      string       `json:"key"`
+		Method   string       `json:"method"`
+		URL      string       `json:"url,omitempty"`
+		Headers  http.Header  `json:"headers,omitempty"`
+		UploadID string       `json:"upload_id,omitempty"`
+		PartSize int64        `json:"part_size,omitempty"`
+		Parts    []uploadPart `json:"parts,omitempty"`
+	}
+
+	uploader, ok := cfg.store.(storage.DirectUploader)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by this storage backend", nil)
+		return
+	}
+
+	video, ok := cfg.getEditableVideoForUpload(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Size is required", nil)
+		return
+	}
+	if params.Size > directUploadMaxSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Video is too large", nil)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil || mediaType != "video/mp4" {
+		respondWithError(w, http.StatusBadRequest, "Video must be an MP4", err)
+		return
+	}
+	if !cfg.checkUploadQuota(w, video, params.Size) {
+		return
+	}
+
+	randomBytes := make([]byte, 32)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random key", err)
+		return
+	}
+	key := directUploadPrefix(video.ID) + base64.RawURLEncoding.EncodeToString(randomBytes) + ".mp4"
+
+	// The size is signed into the URLs, so the client can't upload more than
+	// was checked against the quota
+	if !params.Multipart && params.Size < directUploadMultipartAt {
+		url, err := uploader.PresignPut(r.Context(), key, mediaType, params.Size, directUploadURLExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+			return
+		}
+		respondWithJSON(w, http.StatusCreated, response{
+			Key:     key,
+			Method:  http.MethodPut,
+			URL:     url,
+			Headers: http.Header{"Content-Type": {mediaType}},
+		})
+		return
+	}
+
+	uploadID, err := uploader.CreateMultipartUpload(r.Context(), key, mediaType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start multipart upload", err)
+		return
+	}
+
+	partCount := (params.Size + directUploadPartSize - 1) / directUploadPartSize
+	parts := make([]uploadPart, 0, partCount)
+	for i := int32(1); int64(i) <= partCount; i++ {
+		partSize := min(directUploadPartSize, params.Size-int64(i-1)*directUploadPartSize)
+		url, err := uploader.PresignUploadPart(r.Context(), key, uploadID, i, partSize, directUploadURLExpiry)
+		if err != nil {
+			uploader.AbortMultipartUpload(r.Context(), key, uploadID)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload part", err)
+			return
+		}
+		parts = append(parts, uploadPart{PartNumber: i, URL: url})
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Key:      key,
+		Method:   http.MethodPut,
+		UploadID: uploadID,
+		PartSize: directUploadPartSize,
+		Parts:    parts,
+	})
+}
+
+func (cfg *apiConfig) handlerDirectUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key      string                  `json:"key"`
+		UploadID string                  `json:"upload_id"`
+		Parts    []storage.CompletedPart `json:"parts"`
+	}
+
+	uploader, ok := cfg.store.(storage.DirectUploader)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by this storage backend", nil)
+		return
+	}
+
+	video, ok := cfg.getEditableVideoForUpload(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	// Keys are only ever issued under the video's staging prefix, so this
+	// stops callers from pointing the pipeline at someone else's object.
+	if !strings.HasPrefix(params.Key, directUploadPrefix(video.ID)) || strings.Contains(params.Key, "..") {
+		respondWithError(w, http.StatusBadRequest, "Key doesn't belong to this video", nil)
+		return
+	}
+
+	// A retried callback gets the video back as it is. Going on would queue
+	// the upload twice, or delete it from under the job already queued.
+	job, err := cfg.db.GetActiveJobBySourceKey(params.Key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check for processing job", err)
+		return
+	}
+	if job != nil {
+		cfg.respondWithUploadedVideo(w, r, video.ID)
+		return
+	}
+
+	if params.UploadID != "" {
+		if len(params.Parts) == 0 {
+			respondWithError(w, http.StatusBadRequest, "Parts are required to complete a multipart upload", nil)
+			return
+		}
+		err = uploader.CompleteMultipartUpload(r.Context(), params.Key, params.UploadID, params.Parts)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't complete multipart upload", err)
+			return
+		}
+	}
+
+	info, err := cfg.store.Head(r.Context(), params.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Upload not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify upload", err)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(info.ContentType)
+	if info.Size == 0 || info.Size > directUploadMaxSize || mediaType != "video/mp4" {
+		cfg.deleteObjects(r.Context(), []string{params.Key})
+		respondWithError(w, http.StatusBadRequest, "Upload must be a non-empty MP4", nil)
+		return
+	}
+	// What was uploaded can be larger than the size asked for, so the quota
+	// is checked again
+	if !cfg.checkUploadQuota(w, video, info.Size) {
+		cfg.deleteObjects(r.Context(), []string{params.Key})
+		return
+	}
+
+	err = cfg.enqueueVideoJob(database.CreateJobParams{
+		VideoID:     video.ID,
+		SourceKey:   params.Key,
+		ContentType: mediaType,
+	}, info.Size)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+		return
+	}
+
+	cfg.respondWithUploadedVideo(w, r, video.ID)
+}
+
+// respondWithUploadedVideo responds with 202 and the video an upload was
+// queued for.
+func (cfg *apiConfig) respondWithUploadedVideo(w http.ResponseWriter, r *http.Request, videoID uuid.UUID) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	video, err = cfg.presentVideo(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, video)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultMediaCacheControl lets browsers and proxies keep the media of public
+// videos for good: keys are random or derived from content hashes, and
+// objects are never rewritten with different contents, so a changed asset
+// always has a new URL.
+const defaultMediaCacheControl = "public, max-age=31536000, immutable"
+
+// privateMediaCacheControl keeps everything else out of shared caches, and
+// has browsers revalidate it, since a video can be made private or deleted.
+const privateMediaCacheControl = "private, no-cache"
+
+// handlerMediaStream serves objects from the local store at /assets/{key},
+// with byte ranges so players can seek, and validators so clients can
+// revalidate instead of downloading again. Media in S3 is served by S3 or
+// CloudFront, never through the app.
+func (cfg *apiConfig) handlerMediaStream(w http.ResponseWriter, r *http.Request) {
+	if cfg.storageBackend != "local" {
+		respondWithError(w, http.StatusNotFound, "Media isn't served from here", nil)
+		return
+	}
+
+	key := chi.URLParam(r, "*")
+	if key == "" {
+		respondWithError(w, http.StatusNotFound, "Media not found", nil)
+		return
+	}
+	body, info, err := cfg.store.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		respondWithError(w, http.StatusNotFound, "Media not found", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media", err)
+		return
+	}
+	defer body.Close()
+
+	loc := cfg.storageLocation(key)
+	public, err := cfg.db.IsPublicMedia(loc.Backend, loc.Bucket, loc.Key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check media visibility", err)
+		return
+	}
+	cacheControl := privateMediaCacheControl
+	if public {
+		cacheControl = cfg.mediaCacheControl
+	}
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		w.Header().Set("ETag", quoteETag(info.ETag))
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+
+	// ServeContent answers Range, If-Range, If-None-Match and
+	// If-Modified-Since from the headers above, and needs to seek to do so
+	content, ok := body.(io.ReadSeeker)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't seek in media", nil)
+		return
+	}
+	http.ServeContent(w, r, path.Base(key), info.LastModified, content)
+}
+
+// quoteETag makes etag a valid ETag header value, which stores don't all
+// return.
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMediaStreamCacheControl(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.mediaCacheControl = defaultMediaCacheControl
+	owner := createTestPrincipal(t, cfg, "owner@example.com", auth.RoleCreator)
+	ctx := context.Background()
+
+	for _, title := range []string{"public", "private"} {
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: title, UserID: owner.UserID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		video.VideoLocation = cfg.storageLocation("landscape/" + title + ".mp4")
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			t.Fatal(err)
+		}
+		if title == "public" {
+			if err := cfg.db.SetVideoVisibility(video.ID, database.VisibilityPublic); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, key := range []string{"landscape/" + title + ".mp4", "landscape/" + title + "/hls/master.m3u8"} {
+			if err := cfg.store.Put(ctx, key, strings.NewReader("media"), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := cfg.store.Put(ctx, "thumbnails/orphan.png", strings.NewReader("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/assets/*", cfg.handlerMediaStream)
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"landscape/public.mp4", defaultMediaCacheControl},
+		{"landscape/public/hls/master.m3u8", defaultMediaCacheControl},
+		{"landscape/private.mp4", privateMediaCacheControl},
+		{"landscape/private/hls/master.m3u8", privateMediaCacheControl},
+		{"thumbnails/orphan.png", privateMediaCacheControl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/assets/"+tt.key, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.want {
+				t.Fatalf("Cache-Control: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
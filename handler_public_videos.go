@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/policy"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// publicVideo is what callers without an account get to see of a video.
+type publicVideo struct {
+	ID           uuid.UUID              `json:"id"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+	Title        string                 `json:"title"`
+	Description  string                 `json:"description"`
+	ThumbnailURL *string                `json:"thumbnail_url"`
+	VideoURL     *string                `json:"video_url"`
+	HLSURL       *string                `json:"hls_url"`
+	Visibility   database.Visibility    `json:"visibility"`
+	Metadata     database.MediaMetadata `json:"metadata"`
+}
+
+func newPublicVideo(video database.Video) publicVideo {
+	return publicVideo{
+		ID:           video.ID,
+		CreatedAt:    video.CreatedAt,
+		UpdatedAt:    video.UpdatedAt,
+		Title:        video.Title,
+		Description:  video.Description,
+		ThumbnailURL: video.ThumbnailURL,
+		VideoURL:     video.VideoURL,
+		HLSURL:       video.HLSURL,
+		Visibility:   video.Visibility,
+		Metadata:     video.Metadata,
+	}
+}
+
+// handlerPublicVideoGet returns a public or unlisted video to anyone, and a
+// private one to holders of a share link passed as ?token=. Private videos
+// are reported as missing so their IDs can't be probed.
+func (cfg *apiConfig) handlerPublicVideoGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(chi.URLParam(r, "videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	link := database.ShareLink{}
+	if token := r.URL.Query().Get("token"); token != "" {
+		link, err = cfg.db.GetShareLinkByHash(auth.HashShareToken(token))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+			return
+		}
+	}
+	err = policy.CanViewVideoAnonymously(video, link)
+	if errors.Is(err, policy.ErrDenied) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+
+	err = cfg.setHLSCookies(w, r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media cookies", err)
+		return
+	}
+	video, err = cfg.presentVideo(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+
+	// Links can be revoked, and signed URLs expire, so responses mustn't be
+	// cached for long
+	if video.Visibility == database.VisibilityPrivate {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	respondWithJSON(w, http.StatusOK, newPublicVideo(video))
+}
+
+// handlerPublicVideosList lists every user's public videos, with the same
+// paging and filters as GET /api/videos.
+func (cfg *apiConfig) handlerPublicVideosList(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListVideosParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.Public = true
+	params.IncludeShared = false
+
+	videos, next, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Cursor doesn't match this sort order", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+
+	type response struct {
+		Videos     []publicVideo `json:"videos"`
+		NextCursor *string       `json:"next_cursor"`
+	}
+	videos, err = cfg.presentVideos(r, videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	resp := response{Videos: make([]publicVideo, 0, len(videos))}
+	for _, video := range videos {
+		resp.Videos = append(resp.Videos, newPublicVideo(video))
+	}
+	if next != nil {
+		cursor := next.Encode()
+		resp.NextCursor = &cursor
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// shareLinkPath is where a share link token unlocks its video.
+func shareLinkPath(videoID uuid.UUID, token string) string {
+	return fmt.Sprintf("/api/public/videos/%s?token=%s", videoID, token)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// handlerShareLinksCreate makes a link that lets anyone holding it watch a
+// video, until it expires or is revoked.
+func (cfg *apiConfig) handlerShareLinksCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	type response struct {
+		database.ShareLink
+		// Token and URL are only ever shown in this response.
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}
+
+	principal, video, ok := cfg.getShareableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "expires_at must be in the future", nil)
+		return
+	}
+
+	token, err := auth.MakeShareToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate share token", err)
+		return
+	}
+
+	link, err := cfg.db.CreateShareLink(database.CreateShareLinkParams{
+		VideoID:   video.ID,
+		CreatedBy: principal.UserID,
+		TokenHash: auth.HashShareToken(token),
+		ExpiresAt: params.ExpiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		ShareLink: link,
+		Token:     token,
+		URL:       shareLinkPath(video.ID, token),
+	})
+}
+
+func (cfg *apiConfig) handlerShareLinksList(w http.ResponseWriter, r *http.Request) {
+	_, video, ok := cfg.getShareableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	links, err := cfg.db.ListShareLinks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list share links", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, links)
+}
+
+func (cfg *apiConfig) handlerShareLinksRevoke(w http.ResponseWriter, r *http.Request) {
+	_, video, ok := cfg.getShareableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	linkID, err := uuid.Parse(chi.URLParam(r, "linkID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share link ID", err)
+		return
+	}
+	link, err := cfg.db.GetShareLink(linkID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.ID == uuid.Nil || link.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+
+	err = cfg.db.RevokeShareLink(link.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/policy"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads following the tus 1.0 protocol with the creation,
+// termination and expiration extensions:
+// https://tus.io/protocols/resumable-upload
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusMaxSize    = 1 << 30
+	// tusSweepInterval is how often expired uploads are deleted.
+	tusSweepInterval = time.Hour
+)
+
+// tusUploadLocks holds the IDs of uploads that currently have a PATCH in
+// flight, so two requests to this instance can't write to the same file at
+// once. Across instances, only one request can move the stored offset on.
+var tusUploadLocks sync.Map
+
+// uploadExpired reports whether an unfinished upload has gone without a
+// PATCH for longer than the configured expiry.
+func (cfg *apiConfig) uploadExpired(upload database.Upload) bool {
+	return upload.CompletedAt == nil && time.Since(upload.UpdatedAt) > cfg.uploadExpiry
+}
+
+// setUploadExpires tells the client until when an unfinished upload can be
+// resumed.
+func (cfg *apiConfig) setUploadExpires(w http.ResponseWriter, upload database.Upload) {
+	if upload.CompletedAt != nil {
+		return
+	}
+	w.Header().Set("Upload-Expires", upload.UpdatedAt.Add(cfg.uploadExpiry).UTC().Format(http.TimeFormat))
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// checkTusVersion rejects requests from clients speaking another protocol
+// version. It reports whether the request may continue.
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	setTusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(tusMaxSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	videoID, err := uuid.Parse(chi.URLParam(r, "videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+	userID := principal.UserID
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Missing or invalid Upload-Length", err)
+		return
+	}
+	if length > tusMaxSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds maximum size", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	if !cfg.authorizeVideo(w, principal, video, policy.CanEditVideo, "You can't edit this video") {
+		return
+	}
+	// The whole length is reserved against the quota until the upload is
+	// finished or terminated
+	if !cfg.checkUploadQuota(w, video, length) {
+		return
+	}
+
+	partFile, err := os.CreateTemp(cfg.uploadsDir, "tus-*.part")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	partFile.Close()
+
+	upload, err := cfg.db.CreateUpload(database.CreateUploadParams{
+		VideoID:  videoID,
+		UserID:   userID,
+		Length:   length,
+		Metadata: r.Header.Get("Upload-Metadata"),
+		FilePath: partFile.Name(),
+	})
+	if err != nil {
+		os.Remove(partFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/tus/%s", upload.ID))
+	w.Header().Set("Upload-Offset", "0")
+	cfg.setUploadExpires(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// getTusUpload loads the upload named in the URL and checks that it belongs
+// to the caller. Uploads of other users are reported as missing.
+func (cfg *apiConfig) getTusUpload(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	uploadID, err := uuid.Parse(chi.URLParam(r, "uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return database.Upload{}, false
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return database.Upload{}, false
+	}
+
+	upload, err := cfg.db.GetUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.Upload{}, false
+	}
+	if upload.ID == uuid.Nil || upload.UserID != principal.UserID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return database.Upload{}, false
+	}
+	if cfg.uploadExpired(upload) {
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	cfg.setUploadExpires(w, upload)
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if _, busy := tusUploadLocks.LoadOrStore(upload.ID, struct{}{}); busy {
+		respondWithError(w, http.StatusConflict, "Upload is already being written to", nil)
+		return
+	}
+	defer tusUploadLocks.Delete(upload.ID)
+
+	// Read again now that no other request here can change it
+	upload, err := cfg.db.GetUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	if upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return
+	}
+	if upload.CompletedAt != nil {
+		respondWithError(w, http.StatusForbidden, "Upload is already complete", nil)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing or invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", nil)
+		return
+	}
+
+	partFile, err := os.OpenFile(upload.FilePath, os.O_WRONLY, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	defer partFile.Close()
+
+	_, err = partFile.Seek(offset, io.SeekStart)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't seek upload file", err)
+		return
+	}
+
+	// Whatever arrives before the connection drops is kept, so the client can
+	// resume from the new offset instead of resending the whole chunk.
+	remaining := upload.Length - offset
+	body := http.MaxBytesReader(w, r.Body, remaining)
+
+	// The upload is hashed as it arrives, so it needn't be read again once
+	// it's complete
+	var dst io.Writer = partFile
+	hasher := resumeUploadHash(upload.HashState, offset)
+	if hasher != nil {
+		dst = io.MultiWriter(partFile, hasher)
+	}
+	written, copyErr := io.Copy(dst, body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(copyErr, &maxBytesErr) {
+		// Drop the whole oversized chunk rather than keeping a prefix of it
+		partFile.Truncate(offset)
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk exceeds Upload-Length", copyErr)
+		return
+	}
+
+	newOffset := offset + written
+	moved, err := cfg.db.UpdateUploadOffset(upload.ID, offset, newOffset, hasher.state(written))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	if !moved {
+		// A request to another instance wrote the same chunk first
+		respondWithError(w, http.StatusConflict, "Upload was written to by another request", nil)
+		return
+	}
+	if copyErr != nil {
+		log.Printf("Upload %s interrupted at offset %d: %v", upload.ID, newOffset, copyErr)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read upload chunk", copyErr)
+		return
+	}
+
+	if newOffset == upload.Length {
+		partFile.Close()
+		sourceSHA256 := ""
+		if hasher.state(written) != "" {
+			sourceSHA256 = hasher.sum()
+		}
+		err = cfg.finishTusUpload(upload, sourceSHA256)
+		if errors.Is(err, errNotMP4) {
+			respondWithError(w, http.StatusUnsupportedMediaType, "Video must be an MP4", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if newOffset < upload.Length {
+		upload.UpdatedAt = time.Now()
+		cfg.setUploadExpires(w, upload)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var errNotMP4 = errors.New("upload is not an MP4 video")
+
+// finishTusUpload hands a fully assembled upload to the video pipeline. An
+// empty sourceSHA256 leaves hashing the upload to the worker.
+func (cfg *apiConfig) finishTusUpload(upload database.Upload, sourceSHA256 string) error {
+	partFile, err := os.Open(upload.FilePath)
+	if err != nil {
+		return err
+	}
+	buffer := make([]byte, 512)
+	n, err := partFile.Read(buffer)
+	partFile.Close()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if http.DetectContentType(buffer[:n]) != "video/mp4" {
+		os.Remove(upload.FilePath)
+		if err := cfg.db.DeleteUpload(upload.ID); err != nil {
+			log.Printf("Couldn't delete rejected upload %s: %v", upload.ID, err)
+		}
+		return errNotMP4
+	}
+
+	sourcePath := filepath.Join(cfg.uploadsDir, fmt.Sprintf("upload-%s.mp4", upload.ID))
+	err = os.Rename(upload.FilePath, sourcePath)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.enqueueVideoJob(database.CreateJobParams{
+		VideoID:      upload.VideoID,
+		SourcePath:   sourcePath,
+		SourceSHA256: sourceSHA256,
+		ContentType:  "video/mp4",
+	}, upload.Length)
+	if err != nil {
+		// Put the file back where the upload expects it, so the client can
+		// retry the last PATCH and the sweeper can find it if they don't
+		if renameErr := os.Rename(sourcePath, upload.FilePath); renameErr != nil {
+			log.Printf("Couldn't move %s back to upload %s: %v", sourcePath, upload.ID, renameErr)
+		}
+		return err
+	}
+	return cfg.db.CompleteUpload(upload.ID)
+}
+
+// startUploadSweeper deletes unfinished uploads once they've expired, every
+// tusSweepInterval until ctx is done.
+func (cfg *apiConfig) startUploadSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(tusSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			n, err := cfg.deleteExpiredUploads()
+			if err != nil {
+				log.Printf("Couldn't delete expired uploads: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Deleted %d expired uploads", n)
+			}
+		}
+	}()
+}
+
+// deleteExpiredUploads deletes the files and rows of unfinished uploads that
+// haven't been written to within the expiry, and returns how many it deleted.
+func (cfg *apiConfig) deleteExpiredUploads() (int, error) {
+	uploads, err := cfg.db.ListExpiredUploads(time.Now().Add(-cfg.uploadExpiry))
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, upload := range uploads {
+		if _, busy := tusUploadLocks.LoadOrStore(upload.ID, struct{}{}); busy {
+			continue
+		}
+		err := os.Remove(upload.FilePath)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			err = cfg.db.DeleteUpload(upload.ID)
+		}
+		tusUploadLocks.Delete(upload.ID)
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+	if _, busy := tusUploadLocks.LoadOrStore(upload.ID, struct{}{}); busy {
+		respondWithError(w, http.StatusConflict, "Upload is being written to", nil)
+		return
+	}
+	defer tusUploadLocks.Delete(upload.ID)
+
+	if upload.CompletedAt == nil {
+		err := os.Remove(upload.FilePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload file", err)
+			return
+		}
+	}
+	err := cfg.db.DeleteUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
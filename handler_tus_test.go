@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// testMP4 is enough of an MP4 header to pass content sniffing.
+var testMP4 = append([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), bytes.Repeat([]byte{0}, 100)...)
+
+func newTusRouter(cfg *apiConfig, p auth.Principal) http.Handler {
+	r := chi.NewRouter()
+	r.Use(signedInAs(p))
+	r.Post("/api/video_upload/{videoID}/tus", cfg.handlerTusCreate)
+	r.Head("/api/tus/{uploadID}", cfg.handlerTusHead)
+	r.Patch("/api/tus/{uploadID}", cfg.handlerTusPatch)
+	r.Delete("/api/tus/{uploadID}", cfg.handlerTusDelete)
+	return r
+}
+
+func tusRequest(t *testing.T, router http.Handler, method, target string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func tusPatch(t *testing.T, router http.Handler, location string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	return tusRequest(t, router, http.MethodPatch, location, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	}, chunk)
+}
+
+// createTusUpload creates a video owned by p and starts a tus upload of
+// length bytes for it, returning the upload's URL.
+func createTusUpload(t *testing.T, cfg *apiConfig, router http.Handler, p auth.Principal, length int) (database.Video, string) {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "upload", UserID: p.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := tusRequest(t, router, http.MethodPost, "/api/video_upload/"+video.ID.String()+"/tus", map[string]string{
+		"Upload-Length": strconv.Itoa(length),
+	}, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Upload-Offset") != "0" || rec.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("create: unexpected headers %v", rec.Header())
+	}
+	return video, rec.Header().Get("Location")
+}
+
+func TestTusUpload(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := createTestPrincipal(t, cfg, "owner@example.com", auth.RoleCreator)
+	router := newTusRouter(cfg, owner)
+	video, location := createTusUpload(t, cfg, router, owner, len(testMP4))
+
+	split := 40
+	rec := tusPatch(t, router, location, 0, testMP4[:split])
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(split) {
+		t.Fatalf("first chunk: got %d, offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	// A client that lost track resends from a stale offset and learns the
+	// real one
+	rec = tusPatch(t, router, location, 0, testMP4[:split])
+	if rec.Code != http.StatusConflict || rec.Header().Get("Upload-Offset") != strconv.Itoa(split) {
+		t.Fatalf("stale offset: got %d, offset %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	rec = tusRequest(t, router, http.MethodHead, location, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("head: got %d", rec.Code)
+	}
+	if rec.Header().Get("Upload-Offset") != strconv.Itoa(split) || rec.Header().Get("Upload-Length") != strconv.Itoa(len(testMP4)) {
+		t.Fatalf("head: unexpected headers %v", rec.Header())
+	}
+
+	rec = tusPatch(t, router, location, split, testMP4[split:])
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(len(testMP4)) {
+		t.Fatalf("last chunk: got %d, offset %q: %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body)
+	}
+	if rec.Header().Get("Upload-Expires") != "" {
+		t.Error("a finished upload shouldn't expire")
+	}
+
+	job, err := cfg.db.ClaimJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.VideoID != video.ID {
+		t.Fatalf("expected a job for the video, got %+v", job)
+	}
+	sum := sha256.Sum256(testMP4)
+	if job.SourceSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("job hash: got %q, want the upload's SHA-256", job.SourceSHA256)
+	}
+	source, err := os.ReadFile(job.SourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(source, testMP4) {
+		t.Error("the assembled upload doesn't match what was sent")
+	}
+
+	rec = tusPatch(t, router, location, len(testMP4), []byte("more"))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("patch after completion: got %d", rec.Code)
+	}
+}
+
+func TestTusUploadRejects(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := createTestPrincipal(t, cfg, "owner@example.com", auth.RoleCreator)
+	other := createTestPrincipal(t, cfg, "other@example.com", auth.RoleCreator)
+	router := newTusRouter(cfg, owner)
+	_, location := createTusUpload(t, cfg, router, owner, 10)
+
+	rec := tusPatch(t, newTusRouter(cfg, other), location, 0, []byte("abc"))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("another user's upload: got %d, want 404", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, location, bytes.NewReader([]byte("abc")))
+	req.Header.Set("Tus-Resumable", "0.2.2")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("other protocol version: got %d, Tus-Version %q", rec.Code, rec.Header().Get("Tus-Version"))
+	}
+
+	rec = tusRequest(t, router, http.MethodPatch, location, map[string]string{
+		"Content-Type":  "application/octet-stream",
+		"Upload-Offset": "0",
+	}, []byte("abc"))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: got %d, want 415", rec.Code)
+	}
+
+	rec = tusPatch(t, router, location, 0, bytes.Repeat([]byte("x"), 11))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunk past the length: got %d, want 413", rec.Code)
+	}
+	// The oversized chunk is dropped whole
+	rec = tusRequest(t, router, http.MethodHead, location, nil, nil)
+	if rec.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("offset after an oversized chunk: got %q, want 0", rec.Header().Get("Upload-Offset"))
+	}
+
+	rec = tusPatch(t, router, location, 0, []byte("plain text"))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("upload that isn't an MP4: got %d, want 415", rec.Code)
+	}
+}
+
+func TestTusUploadExpiry(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := createTestPrincipal(t, cfg, "owner@example.com", auth.RoleCreator)
+	router := newTusRouter(cfg, owner)
+	_, location := createTusUpload(t, cfg, router, owner, len(testMP4))
+	_, finished := createTusUpload(t, cfg, router, owner, len(testMP4))
+	if rec := tusPatch(t, router, finished, 0, testMP4); rec.Code != http.StatusNoContent {
+		t.Fatalf("finishing upload: got %d", rec.Code)
+	}
+
+	// A negative expiry makes every unfinished upload stale already
+	cfg.uploadExpiry = -time.Hour
+
+	rec := tusPatch(t, router, location, 0, testMP4)
+	if rec.Code != http.StatusGone {
+		t.Fatalf("expired upload: got %d, want 410", rec.Code)
+	}
+
+	deleted, err := cfg.deleteExpiredUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Fatalf("deleted %d uploads, want only the unfinished one", deleted)
+	}
+	rec = tusRequest(t, router, http.MethodHead, location, nil, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("head of a deleted upload: got %d, want 404", rec.Code)
+	}
+	rec = tusRequest(t, router, http.MethodHead, finished, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("head of a finished upload: got %d, want 200", rec.Code)
+	}
+}
+
+func TestTusUploadRetriesFailedCompletion(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := createTestPrincipal(t, cfg, "owner@example.com", auth.RoleCreator)
+	router := newTusRouter(cfg, owner)
+	video, location := createTusUpload(t, cfg, router, owner, len(testMP4))
+
+	// Jobs can't be queued while the trigger is in place
+	db, err := sql.Open("sqlite3", testDBPath(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`CREATE TRIGGER jobs_down BEFORE INSERT ON jobs BEGIN SELECT RAISE(ABORT, 'jobs are down'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := tusPatch(t, router, location, 0, testMP4)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("completing while jobs are down: got %d, want 500", rec.Code)
+	}
+	uploadID, err := uuid.Parse(path.Base(location))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := cfg.db.GetUpload(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.CompletedAt != nil {
+		t.Fatal("the upload shouldn't be complete without a job")
+	}
+	data, err := os.ReadFile(upload.FilePath)
+	if err != nil {
+		t.Fatalf("the upload's file should be back in place: %v", err)
+	}
+	if !bytes.Equal(data, testMP4) {
+		t.Fatal("the upload's file changed")
+	}
+
+	_, err = db.Exec(`DROP TRIGGER jobs_down`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = tusPatch(t, router, location, len(testMP4), nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("retrying the last PATCH: got %d: %s", rec.Code, rec.Body)
+	}
+	job, err := cfg.db.ClaimJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.VideoID != video.ID {
+		t.Fatalf("expected a job for the video, got %+v", job)
+	}
+	sum := sha256.Sum256(testMP4)
+	if job.SourceSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("job hash: got %q, want the upload's SHA-256", job.SourceSHA256)
+	}
+	if _, err := os.Stat(upload.FilePath); !os.IsNotExist(err) {
+		t.Errorf("the upload's file should have moved to the job: %v", err)
+	}
+}
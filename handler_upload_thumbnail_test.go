@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// testPNG is enough of a PNG header to pass content sniffing.
+var testPNG = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 100)...)
+
+func postThumbnail(t *testing.T, cfg *apiConfig, p auth.Principal, videoID uuid.UUID) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="thumbnail"; filename="thumb.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(testPNG)
+	form.Close()
+
+	r := chi.NewRouter()
+	r.Use(signedInAs(p))
+	r.Post("/api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
+	req := httptest.NewRequest(http.MethodPost, "/api/thumbnail_upload/"+videoID.String(), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadThumbnail(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := createTestPrincipal(t, cfg, "owner@example.com", auth.RoleCreator)
+	admin := createTestPrincipal(t, cfg, "admin@example.com", auth.RoleAdmin)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "video", UserID: owner.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postThumbnail(t, cfg, owner, video.ID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("owner: got %d: %s", rec.Code, rec.Body)
+	}
+
+	// Admins may edit any video, but that doesn't make a missing one exist
+	rec = postThumbnail(t, cfg, admin, uuid.New())
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing video: got %d, want 404", rec.Code)
+	}
+	objects, err := cfg.store.List(context.Background(), thumbnailKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("got %d stored thumbnails, want only the owner's", len(objects))
+	}
+}
@@ -1,334 +1,549 @@
 package main
 
 import (
-    "context"
-    "crypto/rand"
-    "encoding/base64"
-    "encoding/json"
-    "fmt"
-    "io"
-    "log"
-    "mime"
-    "net/http"
-    "os"
-    "strings"
-
-
-    "github.com/aws/aws-sdk-go-v2/service/s3"
-    "github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-    "github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-    "github.com/go-chi/chi/v5"
-    "github.com/google/uuid"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/policy"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
-    type parameters struct {
-        database.CreateVideoParams
-    }
-
-    userIDStr, ok := r.Context().Value("userID").(string)
-    if !ok {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't find userID in context", nil)
-        return
-    }
-    userID, err := uuid.Parse(userIDStr)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Invalid userID format", err)
-        return
-    }
-
-    decoder := json.NewDecoder(r.Body)
-    params := parameters{}
-    err = decoder.Decode(&params)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
-        return
-    }
-    params.UserID = userID
-
-    video, err := cfg.db.CreateVideo(params.CreateVideoParams)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
-        return
-    }
-
-    respondWithJSON(w, http.StatusCreated, video)
+	type parameters struct {
+		database.CreateVideoParams
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+	userID := principal.UserID
+	err := policy.CanCreateVideo(principal)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "You can't create videos", err)
+		return
+	}
+	if !cfg.checkVideoQuota(w, userID) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+	params.UserID = userID
+
+	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, video)
+}
+
+// handlerVideoMetaUpdate changes the fields given in the body. Changing who
+// can see a video takes more than being allowed to edit it.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string              `json:"title"`
+		Description *string              `json:"description"`
+		Visibility  *database.Visibility `json:"visibility"`
+	}
+
+	videoID, err := uuid.Parse(chi.URLParam(r, "videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title != nil && *params.Title == "" {
+		respondWithError(w, http.StatusBadRequest, "Title can't be empty", nil)
+		return
+	}
+	if params.Visibility != nil && !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+
+	if params.Title != nil || params.Description != nil {
+		if !cfg.authorizeVideo(w, principal, video, policy.CanEditVideo, "You can't edit this video") {
+			return
+		}
+		if params.Title != nil {
+			video.Title = *params.Title
+		}
+		if params.Description != nil {
+			video.Description = *params.Description
+		}
+	}
+	if params.Visibility != nil {
+		if !cfg.authorizeVideo(w, principal, video, policy.CanShareVideo, "You can't change who can see this video") {
+			return
+		}
+	}
+
+	if params.Title != nil || params.Description != nil {
+		err = cfg.db.SetVideoDetails(video.ID, video.Title, video.Description)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	if params.Visibility != nil {
+		err = cfg.db.SetVideoVisibility(video.ID, *params.Visibility)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	video, err = cfg.presentVideo(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-    log.Printf("Using jwtSecret: %s", cfg.jwtSecret)
-    videoIDString := r.PathValue("videoID")
-    log.Printf("Attempting to delete video with ID: %s", videoIDString)
-    videoID, err := uuid.Parse(videoIDString)
-    if err != nil {
-        log.Printf("Invalid video ID format: %v", err)
-        respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-        return
-    }
-
-    token, err := auth.GetBearerToken(r.Header)
-    if err != nil {
-        log.Printf("No JWT token found: %v", err)
-        respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-        return
-    }
-    log.Printf("Validating token: %s", token[:50]+"...")
-    userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-    if err != nil {
-        log.Printf("JWT validation failed: %v", err)
-        respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-        return
-    }
-    log.Printf("Authenticated user ID: %s", userID)
-
-    video, err := cfg.db.GetVideo(videoID)
-    if err != nil {
-        log.Printf("Failed to get video %s: %v", videoID, err)
-        respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
-        return
-    }
-    log.Printf("Video found: %+v", video)
-
-    if video.UserID != userID {
-        log.Printf("User %s not authorized to delete video owned by %s", userID, video.UserID)
-        respondWithError(w, http.StatusForbidden, "You can't delete this video", nil)
-        return
-    }
-
-    if video.VideoURL != nil && *video.VideoURL != "" {
-        parts := strings.Split(*video.VideoURL, "/")
-        if len(parts) >= 4 {
-            s3Key := strings.Join(parts[3:], "/")
-            log.Printf("Deleting S3 object with key: %s", s3Key)
-            _, err := cfg.s3Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
-                Bucket: &cfg.s3Bucket,
-                Key:    &s3Key,
-            })
-            if err != nil {
-                log.Printf("Failed to delete S3 object %s: %v", s3Key, err)
-            }
-        }
-    }
-
-    err = cfg.db.DeleteVideo(videoID)
-    if err != nil {
-        log.Printf("Failed to delete video %s: %v", videoID, err)
-        respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
-        return
-    }
-
-    log.Printf("Successfully deleted video %s", videoID)
-    w.WriteHeader(http.StatusNoContent)
+	videoIDString := r.PathValue("videoID")
+	log.Printf("Attempting to delete video with ID: %s", videoIDString)
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		log.Printf("Invalid video ID format: %v", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+	userID := principal.UserID
+	log.Printf("Authenticated user ID: %s", userID)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Failed to get video %s: %v", videoID, err)
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	log.Printf("Video found: %+v", video)
+
+	if !cfg.authorizeVideo(w, principal, video, policy.CanDeleteVideo, "You can't delete this video") {
+		log.Printf("User %s not authorized to delete video owned by %s", userID, video.UserID)
+		return
+	}
+
+	err = cfg.deleteVideo(r.Context(), video)
+	if err != nil {
+		log.Printf("Failed to delete video %s: %v", videoID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+
+	log.Printf("Successfully deleted video %s", videoID)
+	w.WriteHeader(http.StatusNoContent)
 }
 
+// deleteVideo removes a video's stored media and then the video itself.
+// Media that can't be deleted is queued to be retried, and media in a store
+// other than the configured one is left alone.
+func (cfg *apiConfig) deleteVideo(ctx context.Context, video database.Video) error {
+	// Processed media shared with other videos stays until the last of them
+	// is deleted
+	released, err := cfg.db.SetVideoBlob(video.ID, "")
+	if err != nil {
+		return err
+	}
+	keys, err := cfg.unusedMediaKeys(ctx, video, released)
+	if err != nil {
+		log.Printf("Couldn't find every object of video %s, the rest is left to garbage collection: %v", video.ID, err)
+	}
+	cfg.deleteObjects(ctx, keys)
+
+	return cfg.db.DeleteVideo(video.ID)
+}
 
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
-    videoIDString := r.PathValue("videoID")
-    videoID, err := uuid.Parse(videoIDString)
-    if err != nil {
-        respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-        return
-    }
-
-    userIDStr, ok := r.Context().Value("userID").(string)
-    if !ok {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't find userID in context", nil)
-        return
-    }
-
-    video, err := cfg.db.GetVideo(videoID)
-    if err != nil {
-        respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
-        return
-    }
-    if video.UserID.String() != userIDStr {
-        respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
-        return
-    }
-
-    respondWithJSON(w, http.StatusOK, video) // Return the video directly
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if !cfg.authorizeVideo(w, principal, video, policy.CanViewVideo, "You can't view this video") {
+		return
+	}
+
+	err = cfg.setHLSCookies(w, r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media cookies", err)
+		return
+	}
+	video, err = cfg.presentVideo(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video) // Return the video directly
+}
+
+func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	log.Printf("handlerVideosRetrieve called for request: %s", r.URL.Path)
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+	cfg.respondWithVideoPage(w, r, principal.UserID)
 }
 
+// respondWithVideoPage lists userID's videos with the paging, sorting and
+// filtering options in the query string.
+func (cfg *apiConfig) respondWithVideoPage(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	params, err := parseListVideosParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.UserID = userID
+
+	videos, next, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Cursor doesn't match this sort order", err)
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't get videos: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	log.Printf("Retrieved %d videos", len(videos))
+	videos, err = cfg.presentVideos(r, videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+	resp := response{Videos: videos}
+	if next != nil {
+		cursor := next.Encode()
+		resp.NextCursor = &cursor
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
 
+const (
+	defaultVideosPageSize = 20
+	maxVideosPageSize     = 100
+)
 
-func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-    log.Printf("handlerVideosRetrieve called for request: %s", r.URL.Path)
-
-    userIDStr, ok := r.Context().Value("userID").(string)
-    if !ok {
-        log.Printf("Couldn't find userID in context")
-        respondWithError(w, http.StatusInternalServerError, "Couldn't find userID in context", nil)
-        return
-    }
-    log.Printf("UserID from context: %s", userIDStr)
-
-    userID, err := uuid.Parse(userIDStr)
-    if err != nil {
-        log.Printf("Invalid userID format: %v", err)
-        respondWithError(w, http.StatusInternalServerError, "Invalid userID format", err)
-        return
-    }
-
-    videos, err := cfg.db.GetVideos(userID)
-    if err != nil {
-        log.Printf("Couldn't get videos: %v", err)
-        respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
-        return
-    }
-    log.Printf("Retrieved %d videos", len(videos))
-
-
-    log.Printf("Returning %d videos", len(videos))
-    respondWithJSON(w, http.StatusOK, videos) 
+// parseListVideosParams reads the paging, sorting and filtering options of
+// GET /api/videos from the query string.
+func parseListVideosParams(r *http.Request) (database.ListVideosParams, error) {
+	query := r.URL.Query()
+	params := database.ListVideosParams{
+		Limit: defaultVideosPageSize,
+		Sort:  database.VideoSortCreated,
+		Desc:  true,
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxVideosPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxVideosPageSize)
+		}
+		params.Limit = n
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		params.Sort = database.VideoSort(sort)
+		if !params.Sort.Valid() {
+			return params, fmt.Errorf("unknown sort %q, expected created, updated, title or duration", sort)
+		}
+		// Titles read naturally A to Z; everything else newest/longest first
+		params.Desc = params.Sort != database.VideoSortTitle
+	}
+	switch query.Get("order") {
+	case "":
+	case "asc":
+		params.Desc = false
+	case "desc":
+		params.Desc = true
+	default:
+		return params, errors.New("order must be asc or desc")
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := database.DecodeVideoCursor(cursor)
+		if err != nil {
+			return params, err
+		}
+		params.After = &after
+	}
+
+	for name, dest := range map[string]**bool{
+		"has_video":     &params.HasVideo,
+		"has_thumbnail": &params.HasThumbnail,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return params, fmt.Errorf("%s must be true or false", name)
+		}
+		*dest = &b
+	}
+
+	if includeShared := query.Get("include_shared"); includeShared != "" {
+		b, err := strconv.ParseBool(includeShared)
+		if err != nil {
+			return params, errors.New("include_shared must be true or false")
+		}
+		params.IncludeShared = b
+	}
+
+	params.Visibility = database.Visibility(query.Get("visibility"))
+	if params.Visibility != "" && !params.Visibility.Valid() {
+		return params, errors.New("visibility must be private, unlisted or public")
+	}
+
+	params.AspectRatio = query.Get("aspect_ratio")
+	switch params.AspectRatio {
+	case "", "landscape", "portrait", "other":
+	default:
+		return params, errors.New("aspect_ratio must be landscape, portrait or other")
+	}
+
+	for name, dest := range map[string]**time.Time{
+		"created_after":  &params.CreatedAfter,
+		"created_before": &params.CreatedBefore,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := parseDateParam(value)
+		if err != nil {
+			return params, fmt.Errorf("%s must be a date (2006-01-02) or RFC 3339 timestamp", name)
+		}
+		*dest = &t
+	}
+
+	return params, nil
 }
 
+func parseDateParam(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-    r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
-
-    videoIDStr := chi.URLParam(r, "videoID")
-    videoID, err := uuid.Parse(videoIDStr)
-    if err != nil {
-        respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
-        return
-    }
-
-    userIDStr, ok := r.Context().Value("userID").(string)
-    if !ok {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't find userID in context", nil)
-        return
-    }
-    userID, err := uuid.Parse(userIDStr)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Invalid userID format", err)
-        return
-    }
-
-    video, err := cfg.db.GetVideo(videoID)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
-        return
-    }
-    if video.UserID != userID {
-        respondWithError(w, http.StatusUnauthorized, "You don't own this video", nil)
-        return
-    }
-
-    err = r.ParseMultipartForm(1<<30)
-    if err != nil {
-        respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
-        return
-    }
-
-    file, header, err := r.FormFile("video")
-    if err != nil {
-        respondWithError(w, http.StatusBadRequest, "Missing video file", err)
-        return
-    }
-    defer file.Close()
-
-    contentType := header.Header.Get("Content-Type")
-    log.Printf("Header Content-Type: %s", contentType)
-
-    buffer := make([]byte, 512)
-    n, err := file.Read(buffer)
-    if err != nil && err != io.EOF {
-        respondWithError(w, http.StatusInternalServerError, "Failed to read file for MIME detection", err)
-        return
-    }
-    detectedType := http.DetectContentType(buffer[:n])
-    log.Printf("Detected Content-Type: %s", detectedType)
-
-    _, err = file.Seek(0, io.SeekStart)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to seek file", err)
-        return
-    }
-
-    mediaType := contentType
-    if mediaType == "" || mediaType == "application/octet-stream" {
-        mediaType = detectedType
-    }
-
-    parsedMediaType, _, err := mime.ParseMediaType(mediaType)
-    if err != nil {
-        respondWithError(w, http.StatusBadRequest, "Invalid media type", err)
-        return
-    }
-    if parsedMediaType != "video/mp4" {
-        log.Printf("Rejecting file with media type: %s", parsedMediaType)
-        respondWithError(w, http.StatusBadRequest, "Video must be an MP4", nil)
-        return
-    }
-
-    tempFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
-        return
-    }
-    defer os.Remove(tempFile.Name())
-
-    _, err = io.Copy(tempFile, file)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to write video to temp file", err)
-        return
-    }
-
-    _, err = tempFile.Seek(0, io.SeekStart)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to seek temp file", err)
-        return
-    }
-
-    processedPath, err := processVideoForFastStart(tempFile.Name())
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to process video for fast start", err)
-        return
-    }
-    defer os.Remove(processedPath)
-
-    processedFile, err := os.Open(processedPath)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to open processed file", err)
-        return
-    }
-    defer processedFile.Close()
-
-    prefix, err := getVideoAspectRatio(processedPath)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to determine aspect ratio", err)
-        return
-    }
-    log.Printf("Aspect ratio prefix: %s", prefix)
-
-    randomBytes := make([]byte, 32)
-    _, err = rand.Read(randomBytes)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't generate random key", err)
-        return
-    }
-    fileKeyBase := base64.RawURLEncoding.EncodeToString(randomBytes)
-    fileKey := fmt.Sprintf("%s/%s.mp4", prefix, fileKeyBase)
-
-    _, err = cfg.s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-        Bucket:      &cfg.s3Bucket,
-        Key:         &fileKey,
-        Body:        processedFile,
-        ContentType: &mediaType,
-    })
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Failed to upload video to S3", err)
-        return
-    }
-
-    // Store the CloudFront URL instead of bucket,key
-    videoURL := fmt.Sprintf("https://%s/%s", cfg.cloudFrontDomain, fileKey)
-    video.VideoURL = &videoURL
-    err = cfg.db.UpdateVideo(video)
-    if err != nil {
-        respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-        return
-    }
-
-    respondWithJSON(w, http.StatusOK, video) 
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
+
+	videoIDStr := chi.URLParam(r, "videoID")
+	videoID, err := uuid.Parse(videoIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	if !cfg.authorizeVideo(w, principal, video, policy.CanEditVideo, "You can't edit this video") {
+		return
+	}
+
+	// Turn away uploads that can't fit before reading them in. The body is a
+	// little larger than the video, which is checked again once it's parsed.
+	if r.ContentLength > 0 && !cfg.checkUploadQuota(w, video, r.ContentLength) {
+		return
+	}
+
+	err = r.ParseMultipartForm(1 << 30)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return
+	}
+
+	file, header, err := r.FormFile("video")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing video file", err)
+		return
+	}
+	defer file.Close()
+	if !cfg.checkUploadQuota(w, video, header.Size) {
+		return
+	}
+
+	contentType := header.Header.Get("Content-Type")
+	log.Printf("Header Content-Type: %s", contentType)
+
+	buffer := make([]byte, 512)
+	n, err := file.Read(buffer)
+	if err != nil && err != io.EOF {
+		respondWithError(w, http.StatusInternalServerError, "Failed to read file for MIME detection", err)
+		return
+	}
+	detectedType := http.DetectContentType(buffer[:n])
+	log.Printf("Detected Content-Type: %s", detectedType)
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to seek file", err)
+		return
+	}
+
+	mediaType := contentType
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType = detectedType
+	}
+
+	parsedMediaType, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid media type", err)
+		return
+	}
+	if parsedMediaType != "video/mp4" {
+		log.Printf("Rejecting file with media type: %s", parsedMediaType)
+		respondWithError(w, http.StatusBadRequest, "Video must be an MP4", nil)
+		return
+	}
+
+	// The upload is kept until its processing job finishes, so it must live
+	// somewhere that survives a restart rather than in the temp dir.
+	uploadFile, err := os.CreateTemp(cfg.uploadsDir, "upload-*.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	defer uploadFile.Close()
+
+	// Hashed on the way to disk, so uploads of the same file can share
+	// processed media
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(uploadFile, hasher), file)
+	if err != nil {
+		os.Remove(uploadFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Failed to write video to disk", err)
+		return
+	}
+
+	err = cfg.enqueueVideoJob(database.CreateJobParams{
+		VideoID:      video.ID,
+		SourcePath:   uploadFile.Name(),
+		SourceSHA256: hex.EncodeToString(hasher.Sum(nil)),
+		ContentType:  parsedMediaType,
+	}, written)
+	if err != nil {
+		os.Remove(uploadFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video for processing", err)
+		return
+	}
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return
+	}
+	video, err = cfg.presentVideo(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, video)
 }
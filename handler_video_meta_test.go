@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func TestUploadVideoMissingVideo(t *testing.T) {
+	cfg := newTestConfig(t)
+	admin := createTestPrincipal(t, cfg, "admin@example.com", auth.RoleAdmin)
+
+	r := chi.NewRouter()
+	r.Use(signedInAs(admin))
+	r.Post("/api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+uuid.NewString(), nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("got %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const maxSearchQueryLength = 256
+
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return
+	}
+	userID := principal.UserID
+
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		respondWithError(w, http.StatusBadRequest, "Query parameter q is required", nil)
+		return
+	}
+	if len(q) > maxSearchQueryLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Search query can be at most %d characters", maxSearchQueryLength), nil)
+		return
+	}
+
+	limit := defaultVideosPageSize
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxVideosPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxVideosPageSize), err)
+			return
+		}
+	}
+
+	includeShared := false
+	if v := r.URL.Query().Get("include_shared"); v != "" {
+		var err error
+		includeShared, err = strconv.ParseBool(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "include_shared must be true or false", err)
+			return
+		}
+	}
+
+	results, err := cfg.db.SearchVideos(database.SearchVideosParams{
+		UserID:        userID,
+		IncludeShared: includeShared,
+		Query:         q,
+		Limit:         limit,
+	})
+	if errors.Is(err, database.ErrEmptySearch) {
+		respondWithError(w, http.StatusBadRequest, "Search query has no words to search for", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	for i := range results {
+		results[i].Video, err = cfg.presentVideo(r, results[i].Video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign media URLs", err)
+			return
+		}
+	}
+
+	type response struct {
+		Results []database.VideoSearchResult `json:"results"`
+	}
+	respondWithJSON(w, http.StatusOK, response{Results: results})
+}
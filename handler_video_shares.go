@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/policy"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// authorizeVideo runs check against the caller's access to video, looking up
+// their grant when they don't own it, and responds with denied if it fails.
+func (cfg *apiConfig) authorizeVideo(w http.ResponseWriter, principal auth.Principal, video database.Video, check policy.VideoCheck, denied string) bool {
+	share := database.VideoShare{}
+	if video.ID != uuid.Nil && video.UserID != principal.UserID {
+		var err error
+		share, err = cfg.db.GetVideoShare(video.ID, principal.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video permissions", err)
+			return false
+		}
+	}
+
+	err := check(principal, video, share)
+	if errors.Is(err, policy.ErrDenied) {
+		respondWithError(w, http.StatusForbidden, denied, err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return false
+	}
+	return true
+}
+
+// getShareableVideo loads the video named by the videoID URL param and checks
+// that the caller may manage who it's shared with.
+func (cfg *apiConfig) getShareableVideo(w http.ResponseWriter, r *http.Request) (auth.Principal, database.Video, bool) {
+	videoID, err := uuid.Parse(chi.URLParam(r, "videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return auth.Principal{}, database.Video{}, false
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find authenticated user", nil)
+		return auth.Principal{}, database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		return auth.Principal{}, database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return auth.Principal{}, database.Video{}, false
+	}
+	if !cfg.authorizeVideo(w, principal, video, policy.CanShareVideo, "You can't share this video") {
+		return auth.Principal{}, database.Video{}, false
+	}
+	return principal, video, true
+}
+
+// handlerVideoSharesCreate grants the user with the given email access to a
+// video, or changes the permission of their existing grant.
+func (cfg *apiConfig) handlerVideoSharesCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email      string                   `json:"email"`
+		Permission database.SharePermission `json:"permission"`
+	}
+
+	principal, video, ok := cfg.getShareableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required", nil)
+		return
+	}
+	if !params.Permission.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Permission must be view or edit", nil)
+		return
+	}
+
+	grantee, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if grantee.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+	if grantee.ID == video.UserID {
+		respondWithError(w, http.StatusBadRequest, "The owner already has access to this video", nil)
+		return
+	}
+
+	existing, err := cfg.db.GetVideoShare(video.ID, grantee.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video share", err)
+		return
+	}
+	share, err := cfg.db.ShareVideo(database.ShareVideoParams{
+		VideoID:    video.ID,
+		UserID:     grantee.ID,
+		Permission: params.Permission,
+		GrantedBy:  principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't share video", err)
+		return
+	}
+
+	status := http.StatusCreated
+	if existing.UserID != uuid.Nil {
+		status = http.StatusOK
+	}
+	respondWithJSON(w, status, share)
+}
+
+func (cfg *apiConfig) handlerVideoSharesList(w http.ResponseWriter, r *http.Request) {
+	_, video, ok := cfg.getShareableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	shares, err := cfg.db.ListVideoShares(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list video shares", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, shares)
+}
+
+func (cfg *apiConfig) handlerVideoSharesRevoke(w http.ResponseWriter, r *http.Request) {
+	_, video, ok := cfg.getShareableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	share, err := cfg.db.GetVideoShare(video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video share", err)
+		return
+	}
+	if share.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video isn't shared with that user", nil)
+		return
+	}
+
+	err = cfg.db.DeleteVideoShare(video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke video share", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,6 +2,8 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -20,6 +22,10 @@ const (
 	TokenTypeAccess TokenType = "tubely-access"
 )
 
+// TokenAudience is the aud claim of access tokens, so tokens minted for
+// another service with the same secret aren't accepted here.
+const TokenAudience = "tubely-api"
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
 func HashPassword(password string) (string, error) {
@@ -34,44 +40,64 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// MakeJWT signs an access token with the current signing key of keys.
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	keys *KeySet,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+	signingKey, err := keys.signingKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(signingKey.method(), jwt.RegisteredClaims{
 		Issuer:    string(TokenTypeAccess),
+		Audience:  jwt.ClaimStrings{TokenAudience},
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
 	})
-	return token.SignedString(signingKey)
+	token.Header["kid"] = signingKey.ID
+	return token.SignedString(signingKey.Private)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+// ValidateJWT checks an access token's signature against the published key
+// named by its kid, and its algorithm, issuer, audience and expiry. It
+// returns the user the token was issued to.
+func ValidateJWT(tokenString string, keys *KeySet) (uuid.UUID, error) {
 	claimsStruct := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			key, ok := keys.lookup(kid)
+			if !ok {
+				return nil, fmt.Errorf("unknown signing key %q", kid)
+			}
+			// Pin the algorithm to the key's, so a token can't pick how
+			// its own signature is checked.
+			if token.Method.Alg() != key.Algorithm {
+				return nil, fmt.Errorf("token algorithm %s doesn't match key %s", token.Method.Alg(), kid)
+			}
+			return key.Public, nil
+		},
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}),
+		jwt.WithIssuer(string(TokenTypeAccess)),
+		jwt.WithAudience(TokenAudience),
 	)
 	if err != nil {
 		return uuid.Nil, err
 	}
-
-	userIDString, err := token.Claims.GetSubject()
-	if err != nil {
-		return uuid.Nil, err
+	// The parser only checks exp when it's present
+	if claimsStruct.ExpiresAt == nil {
+		return uuid.Nil, errors.New("token has no expiry")
 	}
 
-	issuer, err := token.Claims.GetIssuer()
+	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
-	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
@@ -114,3 +140,42 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// apiKeyPrefix marks Tubely API keys so they are recognisable in config
+// files and secret scanners.
+const apiKeyPrefix = "tubely_"
+
+// MakeAPIKey returns a new random API key. Only its hash should be stored.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// HashAPIKey returns the digest API keys are stored and looked up by. Keys
+// are long and random, so a fast hash is enough, unlike for passwords.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// MakeShareToken returns a new random token for an anonymous share link.
+// Only its hash should be stored.
+func MakeShareToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// HashShareToken returns the digest share tokens are stored and looked up by.
+// Like API keys they're long and random, so a fast hash is enough.
+func HashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
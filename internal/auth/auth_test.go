@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMakeAPIKey(t *testing.T) {
+	first, err := MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first, apiKeyPrefix) || len(first) != len(apiKeyPrefix)+64 {
+		t.Fatalf("unexpected key %q", first)
+	}
+	if first == second {
+		t.Fatal("two keys are the same")
+	}
+
+	hash := HashAPIKey(first)
+	if hash != HashAPIKey(first) {
+		t.Fatal("hashing the same key twice gave different digests")
+	}
+	if hash == HashAPIKey(second) || strings.Contains(hash, first[len(apiKeyPrefix):]) {
+		t.Fatalf("unexpected hash %q", hash)
+	}
+}
+
+func TestGetAPIKey(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"ApiKey tubely_abc", "tubely_abc", false},
+		{"", "", true},
+		{"Bearer tubely_abc", "", true},
+		{"ApiKey", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.header != "" {
+				headers.Set("Authorization", tt.header)
+			}
+			got, err := GetAPIKey(headers)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("got %q, %v", got, err)
+			}
+		})
+	}
+}
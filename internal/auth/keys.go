@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms supported for access tokens, named as in the JWT alg
+// header.
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+// SigningKey is one key pair used to sign access tokens, identified in token
+// headers and the JWKS by its ID.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+}
+
+// GenerateSigningKey creates a new key pair for algorithm with a random ID.
+func GenerateSigningKey(algorithm string) (SigningKey, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return SigningKey{}, err
+	}
+	key := SigningKey{
+		ID:        base64.RawURLEncoding.EncodeToString(idBytes),
+		Algorithm: algorithm,
+	}
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.Private, key.Public = private, &private.PublicKey
+	case AlgorithmEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.Private, key.Public = private, public
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	return key, nil
+}
+
+// MarshalPrivateKey encodes the private half of key as a PKCS #8 PEM block.
+func (key SigningKey) MarshalPrivateKey() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseSigningKey rebuilds a key stored with MarshalPrivateKey.
+func ParseSigningKey(id, algorithm, privatePEM string) (SigningKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return SigningKey{}, errors.New("signing key isn't PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	key := SigningKey{ID: id, Algorithm: algorithm}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Private, key.Public = private, &private.PublicKey
+	case ed25519.PrivateKey:
+		key.Private, key.Public = private, private.Public()
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	_, isRSA := key.Public.(*rsa.PublicKey)
+	if isRSA != (algorithm == AlgorithmRS256) || (!isRSA && algorithm != AlgorithmEdDSA) {
+		return SigningKey{}, fmt.Errorf("key %s doesn't match algorithm %s", id, algorithm)
+	}
+	return key, nil
+}
+
+func (key SigningKey) method() jwt.SigningMethod {
+	if key.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// KeySet holds the key new tokens are signed with and every key whose tokens
+// are still accepted. It is safe for concurrent use and can be swapped out
+// wholesale when keys rotate.
+type KeySet struct {
+	mu      sync.RWMutex
+	signing *SigningKey
+	keys    map[string]SigningKey
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{keys: map[string]SigningKey{}}
+}
+
+// Replace makes published the set of keys tokens are accepted from, and
+// signing the one new tokens are signed with. signing must be published.
+func (ks *KeySet) Replace(signing SigningKey, published []SigningKey) {
+	keys := make(map[string]SigningKey, len(published))
+	for _, key := range published {
+		keys[key.ID] = key
+	}
+	keys[signing.ID] = signing
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.signing = &signing
+	ks.keys = keys
+}
+
+func (ks *KeySet) signingKey() (SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.signing == nil {
+		return SigningKey{}, errors.New("no signing key available")
+	}
+	return *ks.signing, nil
+}
+
+func (ks *KeySet) lookup(id string) (SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[id]
+	return key, ok
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key tokens are accepted from.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
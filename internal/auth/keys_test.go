@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func generateTestKey(t *testing.T, algorithm string) SigningKey {
+	t.Helper()
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestKeySet(signing SigningKey, published ...SigningKey) *KeySet {
+	keys := NewKeySet()
+	keys.Replace(signing, published)
+	return keys
+}
+
+// signTestToken signs claims with key the way MakeJWT does, so tests can
+// vary what MakeJWT always gets right.
+func signTestToken(t *testing.T, key SigningKey, method jwt.SigningMethod, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestParseSigningKey(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			key := generateTestKey(t, algorithm)
+			pem, err := key.MarshalPrivateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseSigningKey(key.ID, algorithm, pem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.ID != key.ID || parsed.Algorithm != algorithm {
+				t.Fatalf("unexpected key %s %s", parsed.ID, parsed.Algorithm)
+			}
+
+			// A token signed with the parsed key checks out against the
+			// original
+			token, err := MakeJWT(uuid.New(), newTestKeySet(parsed), time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ValidateJWT(token, newTestKeySet(key)); err != nil {
+				t.Fatalf("token from the parsed key: %v", err)
+			}
+		})
+	}
+
+	rsaPEM, err := generateTestKey(t, AlgorithmRS256).MarshalPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseSigningKey("k", AlgorithmEdDSA, rsaPEM); err == nil {
+		t.Error("expected an error for an RSA key stored as EdDSA")
+	}
+	if _, err := ParseSigningKey("k", AlgorithmRS256, "not pem"); err == nil {
+		t.Error("expected an error for a key that isn't PEM")
+	}
+	if _, err := GenerateSigningKey("HS256"); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
+
+func TestValidateJWT(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			key := generateTestKey(t, algorithm)
+			keys := newTestKeySet(key)
+			userID := uuid.New()
+
+			token, err := MakeJWT(userID, keys, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ValidateJWT(token, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != userID {
+				t.Fatalf("got user %s, want %s", got, userID)
+			}
+
+			valid := jwt.RegisteredClaims{
+				Issuer:    string(TokenTypeAccess),
+				Audience:  jwt.ClaimStrings{TokenAudience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				Subject:   userID.String(),
+			}
+			tests := []struct {
+				name   string
+				change func(c *jwt.RegisteredClaims)
+			}{
+				{"expired", func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }},
+				{"no expiry", func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }},
+				{"other issuer", func(c *jwt.RegisteredClaims) { c.Issuer = "someone-else" }},
+				{"other audience", func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"another-api"} }},
+				{"no audience", func(c *jwt.RegisteredClaims) { c.Audience = nil }},
+				{"subject isn't a user", func(c *jwt.RegisteredClaims) { c.Subject = "admin" }},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					claims := valid
+					tt.change(&claims)
+					token := signTestToken(t, key, key.method(), claims)
+					if _, err := ValidateJWT(token, keys); err == nil {
+						t.Fatal("expected the token to be rejected")
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestValidateJWTRejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey := generateTestKey(t, AlgorithmRS256)
+	edKey := generateTestKey(t, AlgorithmEdDSA)
+	keys := newTestKeySet(rsaKey, edKey)
+	claims := jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Audience:  jwt.ClaimStrings{TokenAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		Subject:   uuid.NewString(),
+	}
+
+	// Signed by the Ed25519 key but naming the RSA key's kid
+	mismatched := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	mismatched.Header["kid"] = rsaKey.ID
+	forged, err := mismatched.SignedString(edKey.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(forged, keys); err == nil {
+		t.Error("expected a token whose alg doesn't match its key to be rejected")
+	}
+
+	// HMAC keyed with public key material, the classic confusion attack
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = rsaKey.ID
+	hmacToken, err := hmac.SignedString(rsaKey.Public.(*rsa.PublicKey).N.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(hmacToken, keys); err == nil {
+		t.Error("expected an HS256 token to be rejected")
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = rsaKey.ID
+	noneToken, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(noneToken, keys); err == nil {
+		t.Error("expected an unsigned token to be rejected")
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey := generateTestKey(t, AlgorithmEdDSA)
+	newKey := generateTestKey(t, AlgorithmRS256)
+	keys := newTestKeySet(oldKey)
+	userID := uuid.New()
+
+	oldToken, err := MakeJWT(userID, keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After rotating, new tokens name the new key and the old key's tokens
+	// are accepted until it's no longer published
+	keys.Replace(newKey, []SigningKey{oldKey})
+	newToken, err := MakeJWT(userID, keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(newToken, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != newKey.ID || token.Method.Alg() != AlgorithmRS256 {
+		t.Fatalf("new token header: got %v", token.Header)
+	}
+	for name, tokenString := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := ValidateJWT(tokenString, keys); err != nil {
+			t.Errorf("%s token during rotation: %v", name, err)
+		}
+	}
+
+	keys.Replace(newKey, nil)
+	if _, err := ValidateJWT(oldToken, keys); err == nil {
+		t.Error("expected a token from a retired key to be rejected")
+	}
+	if _, err := ValidateJWT(newToken, keys); err != nil {
+		t.Errorf("new token after retiring the old key: %v", err)
+	}
+
+	if _, err := MakeJWT(userID, NewKeySet(), time.Minute); err == nil {
+		t.Error("expected an error signing without a key")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := generateTestKey(t, AlgorithmRS256)
+	edKey := generateTestKey(t, AlgorithmEdDSA)
+	jwks := newTestKeySet(rsaKey, edKey).JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(jwks.Keys))
+	}
+
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "sig" {
+			t.Errorf("%s: use %q, want sig", jwk.KeyID, jwk.Use)
+		}
+		switch jwk.KeyID {
+		case rsaKey.ID:
+			public := rsaKey.Public.(*rsa.PublicKey)
+			n := new(big.Int).SetBytes(decode(jwk.N))
+			e := new(big.Int).SetBytes(decode(jwk.E))
+			if jwk.KeyType != "RSA" || jwk.Algorithm != AlgorithmRS256 || n.Cmp(public.N) != 0 || e.Int64() != int64(public.E) {
+				t.Errorf("unexpected RSA key %+v", jwk)
+			}
+		case edKey.ID:
+			public := edKey.Public.(ed25519.PublicKey)
+			if jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.Algorithm != AlgorithmEdDSA || !public.Equal(ed25519.PublicKey(decode(jwk.X))) {
+				t.Errorf("unexpected Ed25519 key %+v", jwk)
+			}
+		default:
+			t.Errorf("unexpected key %s", jwk.KeyID)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"
+	ScopeUpload Scope = "upload"
+	ScopeDelete Scope = "delete"
+)
+
+// AllScopes is what a logged-in user can do with their own session.
+var AllScopes = []Scope{ScopeRead, ScopeUpload, ScopeDelete}
+
+// ParseScopes validates scope names, dropping duplicates.
+func ParseScopes(names []string) ([]Scope, error) {
+	scopes := []Scope{}
+	for _, name := range names {
+		scope := Scope(name)
+		valid := false
+		for _, s := range AllScopes {
+			valid = valid || s == scope
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		if !(Principal{Scopes: scopes}).HasScope(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// Role is what a user is allowed to do across the whole service, as opposed
+// to a Scope, which narrows what one set of credentials can do.
+type Role string
+
+const (
+	RoleAdmin   Role = "admin"
+	RoleCreator Role = "creator"
+	RoleViewer  Role = "viewer"
+)
+
+// ParseRole validates a role name.
+func ParseRole(name string) (Role, error) {
+	switch role := Role(name); role {
+	case RoleAdmin, RoleCreator, RoleViewer:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q, expected admin, creator or viewer", name)
+	}
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID uuid.UUID
+	Role   Role
+	Scopes []Scope
+	// APIKeyID is set when the caller authenticated with an API key rather
+	// than a session token.
+	APIKeyID uuid.UUID
+}
+
+func (p Principal) IsAPIKey() bool {
+	return p.APIKeyID != uuid.Nil
+}
+
+func (p Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller stored by Authenticator.Middleware.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+var ErrMissingScope = errors.New("credentials lack the required scope")
+
+// Authenticator checks the credentials on incoming requests.
+type Authenticator struct {
+	Keys *KeySet
+	// LookupAPIKey resolves an "Authorization: ApiKey ..." key to its owner.
+	// API keys are rejected when it's nil.
+	LookupAPIKey func(ctx context.Context, key string) (Principal, error)
+	// LookupRole returns the current role of an authenticated user. It's
+	// looked up on every request so role changes apply immediately; callers
+	// get no role when it's nil.
+	LookupRole func(ctx context.Context, userID uuid.UUID) (Role, error)
+	// Unauthorized writes the response for a request without valid
+	// credentials.
+	Unauthorized func(w http.ResponseWriter, r *http.Request, err error)
+	// Forbidden writes the response for a request whose credentials don't
+	// allow what it's asking for.
+	Forbidden func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// Middleware rejects requests without a valid access token or API key and
+// stores the caller's Principal in the context of the rest.
+func (a Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil {
+			a.Unauthorized(w, r, err)
+			return
+		}
+		if a.LookupRole != nil {
+			principal.Role, err = a.LookupRole(r.Context(), principal.UserID)
+			if err != nil {
+				a.Unauthorized(w, r, err)
+				return
+			}
+		}
+
+		ctx := WithPrincipal(r.Context(), principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a Authenticator) authenticate(r *http.Request) (Principal, error) {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme == "ApiKey" {
+		if a.LookupAPIKey == nil {
+			return Principal{}, errors.New("API keys aren't accepted here")
+		}
+		key, err := GetAPIKey(r.Header)
+		if err != nil {
+			return Principal{}, err
+		}
+		return a.LookupAPIKey(r.Context(), key)
+	}
+
+	token, err := GetBearerToken(r.Header)
+	if err != nil {
+		return Principal{}, err
+	}
+	userID, err := ValidateJWT(token, a.Keys)
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{UserID: userID, Scopes: AllScopes}, nil
+}
+
+// RequireScope only lets through callers whose credentials carry scope. It
+// must run after Middleware.
+func (a Authenticator) RequireScope(scope Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok || !principal.HasScope(scope) {
+				a.Forbidden(w, r, fmt.Errorf("%w: %s", ErrMissingScope, scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"read", "upload", "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(scopes, []Scope{ScopeRead, ScopeUpload}) {
+		t.Fatalf("got %v, want read and upload once each", scopes)
+	}
+
+	if _, err := ParseScopes([]string{"read", "admin"}); err == nil {
+		t.Fatal("expected an error for an unknown scope")
+	}
+}
+
+// newTestAuthenticator accepts the API key "tubely_good", which only has
+// the read scope, and answers rejected requests with a bare status.
+func newTestAuthenticator(owner, keyID uuid.UUID) Authenticator {
+	return Authenticator{
+		Keys: NewKeySet(),
+		LookupAPIKey: func(ctx context.Context, key string) (Principal, error) {
+			if key != "tubely_good" {
+				return Principal{}, errors.New("unknown API key")
+			}
+			return Principal{UserID: owner, Scopes: []Scope{ScopeRead}, APIKeyID: keyID}, nil
+		},
+		Unauthorized: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusUnauthorized)
+		},
+		Forbidden: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusForbidden)
+		},
+	}
+}
+
+func TestMiddlewareAPIKeyScopes(t *testing.T) {
+	owner := uuid.New()
+	keyID := uuid.New()
+	a := newTestAuthenticator(owner, keyID)
+
+	var got Principal
+	handler := func(scope Scope) http.Handler {
+		return a.Middleware(a.RequireScope(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = PrincipalFromContext(r.Context())
+		})))
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		scope         Scope
+		want          int
+	}{
+		{"key with the scope", "ApiKey tubely_good", ScopeRead, http.StatusOK},
+		{"key without the scope", "ApiKey tubely_good", ScopeDelete, http.StatusForbidden},
+		{"unknown key", "ApiKey tubely_bad", ScopeRead, http.StatusUnauthorized},
+		{"no credentials", "", ScopeRead, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = Principal{}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler(tt.scope).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && (got.UserID != owner || got.APIKeyID != keyID || !got.IsAPIKey()) {
+				t.Fatalf("unexpected principal %+v", got)
+			}
+		})
+	}
+
+	// Without a lookup, API keys aren't accepted at all
+	a.LookupAPIKey = nil
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "ApiKey tubely_good")
+	rec := httptest.NewRecorder()
+	handler(ScopeRead).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("API key without a lookup: got %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Name    string    `json:"name"`
+	Prefix  string    `json:"prefix"`
+	KeyHash string    `json:"-"`
+	Scopes  []string  `json:"scopes"`
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		updated_at,
+		last_used_at,
+		revoked_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+	)
+	key.Scopes = strings.Split(scopes, ",")
+	return key, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.exec(query, id, params.UserID, params.Name, params.Prefix, params.KeyHash, strings.Join(params.Scopes, ","))
+	if err != nil {
+		return APIKey{}, err
+	}
+	return c.GetAPIKey(id)
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE id = ?
+	`
+	key, err := scanAPIKey(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ?
+	`
+	key, err := scanAPIKey(c.queryRow(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns all of a user's keys, revoked ones included, newest
+// first.
+func (c Client) ListAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) RenameAPIKey(id uuid.UUID, name string) error {
+	query := `
+	UPDATE api_keys
+	SET name = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, name, id)
+	return err
+}
+
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+// apiKeyTouchInterval limits how often last_used_at is written, so a busy
+// key doesn't turn every request into a database write.
+const apiKeyTouchInterval = time.Minute
+
+// TouchAPIKey records that a key was just used.
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`
+	_, err := c.exec(query, id, c.timeArg(time.Now().Add(-apiKeyTouchInterval)))
+	return err
+}
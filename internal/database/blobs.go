@@ -0,0 +1,222 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob is the processed media made from one source file, shared by every
+// video whose upload had the same contents.
+type Blob struct {
+	CreatedAt time.Time `json:"created_at"`
+	RefCount  int       `json:"ref_count"`
+	CreateBlobParams
+}
+
+type CreateBlobParams struct {
+	SHA256       string `json:"sha256"`
+	Backend      string `json:"backend"`
+	Bucket       string `json:"bucket"`
+	VideoKey     string `json:"video_key"`
+	HLSKey       string `json:"hls_key"`
+	ThumbnailKey string `json:"thumbnail_key"`
+}
+
+const blobColumns = `
+		sha256,
+		created_at,
+		backend,
+		bucket,
+		video_key,
+		hls_key,
+		thumbnail_key,
+		ref_count`
+
+func scanBlob(row rowScanner) (Blob, error) {
+	var blob Blob
+	err := row.Scan(
+		&blob.SHA256,
+		&blob.CreatedAt,
+		&blob.Backend,
+		&blob.Bucket,
+		&blob.VideoKey,
+		&blob.HLSKey,
+		&blob.ThumbnailKey,
+		&blob.RefCount,
+	)
+	return blob, err
+}
+
+// CreateBlob records processed media. A blob that already exists for the
+// hash, such as one in a store the app no longer uses, is pointed at the new
+// objects and keeps its references.
+func (c Client) CreateBlob(params CreateBlobParams) error {
+	query := `
+	INSERT INTO blobs (
+		sha256,
+		created_at,
+		backend,
+		bucket,
+		video_key,
+		hls_key,
+		thumbnail_key,
+		ref_count
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, 0)
+	ON CONFLICT (sha256) DO UPDATE SET
+		backend = excluded.backend,
+		bucket = excluded.bucket,
+		video_key = excluded.video_key,
+		hls_key = excluded.hls_key,
+		thumbnail_key = excluded.thumbnail_key
+	`
+	_, err := c.exec(query,
+		params.SHA256,
+		params.Backend,
+		params.Bucket,
+		params.VideoKey,
+		params.HLSKey,
+		params.ThumbnailKey,
+	)
+	return err
+}
+
+// GetBlob returns a zero Blob if there is none for the hash.
+func (c Client) GetBlob(sha256 string) (Blob, error) {
+	query := `
+	SELECT` + blobColumns + `
+	FROM blobs
+	WHERE sha256 = ?
+	`
+	blob, err := scanBlob(c.queryRow(query, sha256))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Blob{}, nil
+	}
+	return blob, err
+}
+
+// GetBlobMetadata returns the media metadata of a video using the blob,
+// which every video using it shares. It reports false when no video does.
+func (c Client) GetBlobMetadata(sha256 string) (MediaMetadata, bool, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE blob_sha256 = ?
+	LIMIT 1
+	`
+	video, err := scanVideo(c.queryRow(query, sha256))
+	if errors.Is(err, sql.ErrNoRows) {
+		return MediaMetadata{}, false, nil
+	}
+	if err != nil {
+		return MediaMetadata{}, false, err
+	}
+	return video.Metadata, true, nil
+}
+
+// SetVideoBlob makes the video use the blob with the given hash, or none when
+// it is empty, and updates the reference counts of both blobs involved. When
+// that drops the last reference to the blob the video used before, the blob
+// is forgotten and returned so its objects can be deleted. Using a blob that
+// doesn't exist, such as one just released, is an error.
+func (c Client) SetVideoBlob(videoID uuid.UUID, sha256 string) (*Blob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow(c.rebind(`SELECT blob_sha256 FROM videos WHERE id = ?`), videoID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current == sha256 {
+		return nil, nil
+	}
+
+	_, err = tx.Exec(c.rebind(`UPDATE videos SET blob_sha256 = ? WHERE id = ?`), sha256, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if sha256 != "" {
+		res, err := tx.Exec(c.rebind(`UPDATE blobs SET ref_count = ref_count + 1 WHERE sha256 = ?`), sha256)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("blob %s doesn't exist", sha256)
+		}
+	}
+
+	var released *Blob
+	if current != "" {
+		_, err = tx.Exec(c.rebind(`UPDATE blobs SET ref_count = ref_count - 1 WHERE sha256 = ?`), current)
+		if err != nil {
+			return nil, err
+		}
+		blob, err := scanBlob(tx.QueryRow(c.rebind(`SELECT`+blobColumns+` FROM blobs WHERE sha256 = ?`), current))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err == nil && blob.RefCount <= 0 {
+			_, err = tx.Exec(c.rebind(`DELETE FROM blobs WHERE sha256 = ?`), current)
+			if err != nil {
+				return nil, err
+			}
+			released = &blob
+		}
+	}
+	return released, tx.Commit()
+}
+
+// DeleteUnusedBlobs forgets the blobs created before the given time that no
+// video uses, such as ones whose video was deleted while it was processed,
+// and returns them so their objects can be deleted.
+func (c Client) DeleteUnusedBlobs(before time.Time) ([]Blob, error) {
+	query := `
+	SELECT` + blobColumns + `
+	FROM blobs
+	WHERE ref_count <= 0 AND created_at < ?
+	`
+	rows, err := c.query(query, c.timeArg(before))
+	if err != nil {
+		return nil, err
+	}
+	unused := []Blob{}
+	for rows.Next() {
+		blob, err := scanBlob(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		unused = append(unused, blob)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// A blob picked up by a video since it was read stays
+	deleted := []Blob{}
+	for _, blob := range unused {
+		res, err := c.exec(`DELETE FROM blobs WHERE sha256 = ? AND ref_count <= 0`, blob.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			deleted = append(deleted, blob)
+		}
+	}
+	return deleted, nil
+}
@@ -3,87 +3,144 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Dialect is the SQL flavour spoken by the database behind a Client.
+type Dialect string
+
+const (
+	DialectSQLite   Dialect = "sqlite3"
+	DialectPostgres Dialect = "postgres"
+)
+
 type Client struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect Dialect
+	// fts5 is whether SQLite was built with FTS5 (the sqlite_fts5 build
+	// tag). Postgres always has full-text search.
+	fts5 bool
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+// NewClient opens the database and applies any pending migrations.
+func NewClient(dsn string) (Client, error) {
+	c, err := Open(dsn)
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	_, err = c.MigrateUp(0)
 	if err != nil {
 		return Client{}, err
 	}
 	return c, nil
-
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
-	if err != nil {
-		return err
+// Open opens the database without touching its schema. A postgres:// or
+// postgresql:// URL selects Postgres; anything else is a SQLite file path.
+func Open(dsn string) (Client, error) {
+	dialect := DialectSQLite
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		dialect = DialectPostgres
 	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
-	if err != nil {
-		return err
+	if dialect == DialectSQLite {
+		dsn = sqliteDSN(dsn)
 	}
 
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
+	db, err := sql.Open(string(dialect), dsn)
 	if err != nil {
-		return err
+		return Client{}, err
 	}
-	return nil
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return Client{}, fmt.Errorf("failed to connect to %s database: %w", dialect, err)
+	}
+	var fts5 bool
+	if dialect == DialectSQLite {
+		err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+		if err != nil {
+			db.Close()
+			return Client{}, fmt.Errorf("failed to check SQLite compile options: %w", err)
+		}
+	}
+	return Client{db: db, dialect: dialect, fts5: fts5}, nil
 }
 
-func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
-		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
+// sqliteDSN turns on foreign keys for every connection to the SQLite
+// database at path, which SQLite leaves off by default, so that deletes
+// cascade as they do in Postgres.
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_foreign_keys=on"
+}
+
+func (c Client) Dialect() Dialect {
+	return c.dialect
+}
+
+// FullTextSearch reports whether video search uses a full-text index. When
+// it doesn't, searches match words anywhere in titles and descriptions, and
+// rank by where they matched.
+func (c Client) FullTextSearch() bool {
+	return c.dialect == DialectPostgres || c.fts5
+}
+
+func (c Client) Close() error {
+	return c.db.Close()
+}
+
+// rebind rewrites the ? placeholders every query is written with into the
+// $1, $2, ... form Postgres expects. Question marks inside string literals
+// are left alone.
+func (c Client) rebind(query string) string {
+	if c.dialect != DialectPostgres {
+		return query
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'':
+			inString = !inString
+		case ch == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(ch)
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
-		return fmt.Errorf("failed to reset table videos: %w", err)
+	return b.String()
+}
+
+func (c Client) exec(query string, args ...any) (sql.Result, error) {
+	return c.db.Exec(c.rebind(query), args...)
+}
+
+func (c Client) query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.Query(c.rebind(query), args...)
+}
+
+func (c Client) queryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRow(c.rebind(query), args...)
+}
+
+func (c Client) Reset() error {
+	// Children before parents, so this also works where foreign keys are enforced.
+	for _, table := range []string{"share_links", "video_shares", "uploads", "jobs", "api_keys", "refresh_tokens", "pending_deletions", "videos", "blobs", "rate_limits", "users"} {
+		if _, err := c.exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to reset table %s: %w", table, err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,383 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// forEachDialect runs test against a migrated, empty database of every
+// dialect: a SQLite file, and the Postgres database at TEST_DATABASE_URL.
+// Without TEST_DATABASE_URL the Postgres tests are skipped, except in CI
+// where they fail. Postgres tests delete everything in that database.
+func forEachDialect(t *testing.T, test func(t *testing.T, c Client)) {
+	t.Run("sqlite", func(t *testing.T) {
+		c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		test(t, c)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("TEST_DATABASE_URL")
+		if dsn == "" {
+			if os.Getenv("CI") != "" {
+				t.Fatal("TEST_DATABASE_URL must be set in CI")
+			}
+			t.Skip("TEST_DATABASE_URL not set")
+		}
+		c, err := NewClient(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		if err := c.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		test(t, c)
+	})
+}
+
+func createTestUser(t *testing.T, c Client, email string) User {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *user
+}
+
+func createTestVideo(t *testing.T, c Client, userID uuid.UUID, title string) Video {
+	t.Helper()
+	video, err := c.CreateVideo(CreateVideoParams{Title: title, Description: "about " + title, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func TestCreateUser(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c, "a@example.com")
+		if user.ID == uuid.Nil || user.Email != "a@example.com" || user.Role != "creator" {
+			t.Fatalf("unexpected user %+v", user)
+		}
+
+		byEmail, err := c.GetUserByEmail("a@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if byEmail.ID != user.ID {
+			t.Fatalf("GetUserByEmail: got %s, want %s", byEmail.ID, user.ID)
+		}
+
+		_, err = c.CreateUser(CreateUserParams{Email: "a@example.com", Password: "hash"})
+		if err == nil {
+			t.Fatal("expected an error for a duplicate email")
+		}
+
+		missing, err := c.GetUser(uuid.New())
+		if err != nil || missing != nil {
+			t.Fatalf("GetUser of an unknown ID: got %v, %v", missing, err)
+		}
+	})
+}
+
+func TestGetVideos(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		alice := createTestUser(t, c, "alice@example.com")
+		bob := createTestUser(t, c, "bob@example.com")
+		createTestVideo(t, c, alice.ID, "first")
+		createTestVideo(t, c, alice.ID, "second")
+		createTestVideo(t, c, bob.ID, "other")
+
+		videos, err := c.GetVideos(alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(videos) != 2 {
+			t.Fatalf("got %d videos, want 2", len(videos))
+		}
+		for _, video := range videos {
+			if video.UserID != alice.ID {
+				t.Errorf("video %q belongs to %s", video.Title, video.UserID)
+			}
+			if video.Description != "about "+video.Title {
+				t.Errorf("video %q has description %q", video.Title, video.Description)
+			}
+		}
+
+		videos, err = c.GetVideos(uuid.New())
+		if err != nil || len(videos) != 0 {
+			t.Fatalf("unknown user: got %d videos, %v", len(videos), err)
+		}
+	})
+}
+
+func TestListVideosPaging(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c, "a@example.com")
+		// Videos created within the same second tie on created_at, which
+		// the cursor has to break by ID
+		titles := []string{"delta", "alpha", "echo", "charlie", "bravo"}
+		for _, title := range titles {
+			createTestVideo(t, c, user.ID, title)
+		}
+
+		for _, tt := range []struct {
+			sort VideoSort
+			desc bool
+		}{
+			{VideoSortCreated, false},
+			{VideoSortCreated, true},
+			{VideoSortTitle, false},
+			{VideoSortTitle, true},
+		} {
+			params := ListVideosParams{UserID: user.ID, Limit: 2, Sort: tt.sort, Desc: tt.desc}
+			seen := map[uuid.UUID]bool{}
+			var order []string
+			pages := 0
+			for {
+				videos, next, err := c.ListVideos(params)
+				if err != nil {
+					t.Fatalf("%s desc=%v: %v", tt.sort, tt.desc, err)
+				}
+				pages++
+				for _, video := range videos {
+					if seen[video.ID] {
+						t.Fatalf("%s desc=%v: video %q on two pages", tt.sort, tt.desc, video.Title)
+					}
+					seen[video.ID] = true
+					order = append(order, video.Title)
+				}
+				if next == nil {
+					break
+				}
+				// Cursors go through clients in their encoded form
+				decoded, err := DecodeVideoCursor(next.Encode())
+				if err != nil {
+					t.Fatal(err)
+				}
+				params.After = &decoded
+			}
+			if len(seen) != len(titles) || pages != 3 {
+				t.Fatalf("%s desc=%v: got %d videos on %d pages, want %d on 3", tt.sort, tt.desc, len(seen), pages, len(titles))
+			}
+			if tt.sort == VideoSortTitle {
+				want := []string{"alpha", "bravo", "charlie", "delta", "echo"}
+				if tt.desc {
+					want = []string{"echo", "delta", "charlie", "bravo", "alpha"}
+				}
+				for i := range want {
+					if order[i] != want[i] {
+						t.Fatalf("desc=%v: got order %v, want %v", tt.desc, order, want)
+					}
+				}
+			}
+		}
+
+		// A cursor only continues the listing it came from
+		_, next, err := c.ListVideos(ListVideosParams{UserID: user.ID, Limit: 2, Sort: VideoSortTitle})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = c.ListVideos(ListVideosParams{UserID: user.ID, Limit: 2, Sort: VideoSortCreated, After: next})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("mismatched cursor: got %v, want ErrInvalidCursor", err)
+		}
+	})
+}
+
+func TestDecodeVideoCursorRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"", "not base64!", "e30", VideoCursor{Sort: "size", ID: uuid.New()}.Encode()} {
+		if _, err := DecodeVideoCursor(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeVideoCursor(%q): got %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
+
+// TestSearchVideos runs against whichever search the build has: FTS5 with
+// -tags sqlite_fts5, LIKE without it.
+func TestSearchVideos(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c, "a@example.com")
+		other := createTestUser(t, c, "b@example.com")
+		inTitle := createTestVideo(t, c, user.ID, "Pasta at home")
+		inDescription, err := c.CreateVideo(CreateVideoParams{Title: "Hiking", Description: "we ate <b>pasta</b> on top", UserID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		createTestVideo(t, c, user.ID, "Gardening")
+		createTestVideo(t, c, other.ID, "Pasta elsewhere")
+
+		results, err := c.SearchVideos(SearchVideosParams{UserID: user.ID, Query: "pasta", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("got %d results, want 2", len(results))
+		}
+		if results[0].ID != inTitle.ID || results[1].ID != inDescription.ID {
+			t.Fatalf("title matches should rank first: got %q, %q", results[0].Title, results[1].Title)
+		}
+		if results[0].TitleHighlight != "<mark>Pasta</mark> at home" {
+			t.Errorf("title highlight: got %q", results[0].TitleHighlight)
+		}
+		if want := "we ate &lt;b&gt;<mark>pasta</mark>&lt;/b&gt; on top"; results[1].DescriptionSnippet != want {
+			t.Errorf("description snippet: got %q, want %q", results[1].DescriptionSnippet, want)
+		}
+
+		_, err = c.SearchVideos(SearchVideosParams{UserID: user.ID, Query: "!!", Limit: 10})
+		if !errors.Is(err, ErrEmptySearch) {
+			t.Fatalf("query without words: got %v, want ErrEmptySearch", err)
+		}
+	})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c, "a@example.com")
+		expiresAt := time.Now().Add(time.Hour)
+
+		first, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: "first", UserID: user.ID, ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !first.Usable() || first.FamilyID != "first" {
+			t.Fatalf("unexpected token %+v", first)
+		}
+
+		second, err := c.RotateRefreshToken("first", "second", expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !second.Usable() || second.UserID != user.ID || second.FamilyID != "first" {
+			t.Fatalf("unexpected rotated token %+v", second)
+		}
+		first, err = c.GetRefreshToken("first")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Usable() || first.ReplacedBy == nil || *first.ReplacedBy != "second" {
+			t.Fatalf("rotated token should be revoked and point at its replacement: %+v", first)
+		}
+
+		// Replaying the spent token revokes the whole family
+		_, err = c.RotateRefreshToken("first", "third", expiresAt)
+		if !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("replay: got %v, want ErrRefreshTokenReused", err)
+		}
+		second, err = c.GetRefreshToken("second")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.Usable() {
+			t.Fatal("the replacement should be revoked after a replay")
+		}
+		third, err := c.GetRefreshToken("third")
+		if err != nil || third.Token != "" {
+			t.Fatalf("no token should be issued on a replay: got %+v, %v", third, err)
+		}
+
+		_, err = c.RotateRefreshToken("unknown", "fourth", expiresAt)
+		if !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Fatalf("unknown token: got %v, want ErrRefreshTokenInvalid", err)
+		}
+	})
+}
+
+func TestRefreshTokenExpiryAndRevocation(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c, "a@example.com")
+
+		_, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: "expired", UserID: user.ID, ExpiresAt: time.Now().Add(-time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.RotateRefreshToken("expired", "new", time.Now().Add(time.Hour))
+		if !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Fatalf("expired token: got %v, want ErrRefreshTokenInvalid", err)
+		}
+
+		_, err = c.CreateRefreshToken(CreateRefreshTokenParams{Token: "live", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner, err := c.GetUserByRefreshToken("live")
+		if err != nil || owner == nil || owner.ID != user.ID {
+			t.Fatalf("GetUserByRefreshToken: got %v, %v", owner, err)
+		}
+		if err := c.RevokeRefreshToken("live"); err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.GetUserByRefreshToken("live")
+		if !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Fatalf("revoked token: got %v, want ErrRefreshTokenInvalid", err)
+		}
+		_, err = c.RotateRefreshToken("live", "new", time.Now().Add(time.Hour))
+		if !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Fatalf("rotating a revoked token: got %v, want ErrRefreshTokenInvalid", err)
+		}
+	})
+}
+
+func TestIsPublicMedia(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		user := createTestUser(t, c, "a@example.com")
+		local := func(key string) *StorageLocation {
+			return &StorageLocation{Backend: "local", Key: key}
+		}
+		public := createTestVideo(t, c, user.ID, "public")
+		public.ThumbnailLocation = local("thumbnails/public.png")
+		public.VideoLocation = local("landscape/abc.mp4")
+		public.HLSLocation = local("landscape/abc/hls/master.m3u8")
+		private := createTestVideo(t, c, user.ID, "private")
+		private.ThumbnailLocation = local("thumbnails/private.png")
+		private.VideoLocation = local("landscape/abcd.mp4")
+		for _, video := range []Video{public, private} {
+			if err := c.UpdateVideo(video); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.SetVideoVisibility(public.ID, VisibilityPublic); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			key  string
+			want bool
+		}{
+			{"thumbnails/public.png", true},
+			{"landscape/abc.mp4", true},
+			{"landscape/abc/hls/master.m3u8", true},
+			{"landscape/abc/hls/720p/segment0.ts", true},
+			{"landscape/abc/thumbnails/0001.jpg", true},
+			{"thumbnails/private.png", false},
+			{"landscape/abcd.mp4", false},
+			{"landscape/abcd/hls/master.m3u8", false},
+			{"landscape/ab/hls/master.m3u8", false},
+			{"landscape/other.mp4", false},
+			{"abc.mp4", false},
+		}
+		for _, tt := range tests {
+			got, err := c.IsPublicMedia("local", "", tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("IsPublicMedia(%q): got %v, want %v", tt.key, got, tt.want)
+			}
+		}
+
+		// The same key in another store isn't the public video's
+		got, err := c.IsPublicMedia("s3", "bucket", "landscape/abc/hls/master.m3u8")
+		if err != nil || got {
+			t.Errorf("key in another store: got %v, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued  JobStatus = "queued"
+	JobStatusRunning JobStatus = "running"
+	JobStatusDone    JobStatus = "done"
+	JobStatusFailed  JobStatus = "failed"
+)
+
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    JobStatus `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError *string   `json:"last_error"`
+	CreateJobParams
+}
+
+// CreateJobParams names the job's input: either a file in the uploads
+// directory (SourcePath) or an object in the blob store (SourceKey) that the
+// worker downloads first. SourceSHA256 is the input's hash when it's known
+// up front.
+type CreateJobParams struct {
+	VideoID      uuid.UUID `json:"video_id"`
+	SourcePath   string    `json:"source_path"`
+	SourceKey    string    `json:"source_key"`
+	SourceSHA256 string    `json:"source_sha256"`
+	ContentType  string    `json:"content_type"`
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		status,
+		attempts,
+		source_path,
+		source_key,
+		source_sha256,
+		content_type
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, 0, ?, ?, ?, ?)
+	`
+	_, err := c.exec(query, id, params.VideoID, JobStatusQueued, params.SourcePath, params.SourceKey, params.SourceSHA256, params.ContentType)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		status,
+		attempts,
+		last_error,
+		video_id,
+		source_path,
+		source_key,
+		source_sha256,
+		content_type
+	FROM jobs
+	WHERE id = ?
+	`
+
+	var job Job
+	err := c.queryRow(query, id).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Status,
+		&job.Attempts,
+		&job.LastError,
+		&job.VideoID,
+		&job.SourcePath,
+		&job.SourceKey,
+		&job.SourceSHA256,
+		&job.ContentType,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// GetActiveJobBySourceKey returns the newest job for the object at key that
+// is queued, running or done, or nil when there is none.
+func (c Client) GetActiveJobBySourceKey(key string) (*Job, error) {
+	query := `
+	SELECT id
+	FROM jobs
+	WHERE source_key = ? AND status IN (?, ?, ?)
+	ORDER BY created_at DESC
+	LIMIT 1
+	`
+	var id uuid.UUID
+	err := c.queryRow(query, key, JobStatusQueued, JobStatusRunning, JobStatusDone).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job, err := c.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimJob marks the oldest queued job that is due as running and returns it.
+// It returns nil when there is nothing to do.
+func (c Client) ClaimJob() (*Job, error) {
+	for {
+		var id uuid.UUID
+		err := c.queryRow(`
+		SELECT id
+		FROM jobs
+		WHERE status = ? AND (run_after IS NULL OR run_after <= ?)
+		ORDER BY created_at
+		LIMIT 1
+		`, JobStatusQueued, c.timeArg(time.Now())).Scan(&id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		// Only one worker can move the job out of the queued state; if another
+		// one got there first, look for the next job.
+		result, err := c.exec(`
+		UPDATE jobs
+		SET status = ?, attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+		`, JobStatusRunning, id, JobStatusQueued)
+		if err != nil {
+			return nil, err
+		}
+		claimed, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		job, err := c.GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		return &job, nil
+	}
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, last_error = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusDone, id)
+	return err
+}
+
+// FailJob records a failed attempt. When retryAt is set the job goes back to
+// the queue until then, otherwise it is marked as permanently failed.
+func (c Client) FailJob(id uuid.UUID, jobErr error, retryAt *time.Time) error {
+	status := JobStatusFailed
+	var runAfter any
+	if retryAt != nil {
+		status = JobStatusQueued
+		runAfter = c.timeArg(*retryAt)
+	}
+	query := `
+	UPDATE jobs
+	SET status = ?, last_error = ?, run_after = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, status, jobErr.Error(), runAfter, id)
+	return err
+}
+
+// RequeueRunningJobs puts jobs that were running when the process stopped
+// back in the queue. It must only be called before any worker starts.
+func (c Client) RequeueRunningJobs() (int64, error) {
+	query := `
+	UPDATE jobs
+	SET status = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE status = ?
+	`
+	result, err := c.exec(query, JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
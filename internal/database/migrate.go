@@ -0,0 +1,283 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change, read from
+// migrations/<dialect>/<version>_<name>.{up,down}.sql. Both dialects keep the
+// same versions so a deployment can be described by one number.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// NeedsFTS5 is set by a "-- requires: fts5" first line in the up script.
+	// Such a migration stays pending while SQLite is built without FTS5.
+	NeedsFTS5 bool
+}
+
+const fts5Requirement = "-- requires: fts5\n"
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+func (c Client) loadMigrations() ([]Migration, error) {
+	dir := "migrations/" + migrationDir(c.dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>.up.sql or .down.sql", fileName)
+		}
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: missing name", fileName)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", fileName, err)
+		}
+		contents, err := fs.ReadFile(migrationFiles, dir+"/"+fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(contents)
+			m.NeedsFTS5 = strings.HasPrefix(m.Up, fts5Requirement)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func migrationDir(dialect Dialect) string {
+	if dialect == DialectPostgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+func (c Client) tableExists(table string) (bool, error) {
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	if c.dialect == DialectPostgres {
+		query = `
+		SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = ?
+		`
+	}
+	var count int
+	err := c.queryRow(query, table).Scan(&count)
+	return count > 0, err
+}
+
+// ensureMigrationTable creates schema_migrations. Databases created before
+// migrations existed already have tables but no record of them; the
+// baseline migration only creates the tables that are missing, so it
+// adopts them like any other database.
+func (c Client) ensureMigrationTable() error {
+	exists, err := c.tableExists("schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = c.exec(`
+	CREATE TABLE schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	return err
+}
+
+// applyMigration runs one migration and records the result in the same
+// transaction, so a failing script leaves no trace.
+func (c Client) applyMigration(m Migration, up bool) error {
+	ctx := context.Background()
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if c.dialect == DialectSQLite {
+		// SQLite changes a column's type by rebuilding the table, and with
+		// foreign keys enforced, dropping the old table would delete the
+		// rows that point at it. The pragma has no effect inside a
+		// transaction, so it's set on the connection first.
+		_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF")
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if _, err := tx.Exec(m.Up); err != nil {
+			return fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec(c.rebind(`
+		INSERT INTO schema_migrations (version, name, applied_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		`), m.Version, m.Name)
+	} else {
+		if m.Down == "" {
+			return fmt.Errorf("migration %d_%s can't be reverted: no down script", m.Version, m.Name)
+		}
+		if _, err := tx.Exec(m.Down); err != nil {
+			return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec(c.rebind(`DELETE FROM schema_migrations WHERE version = ?`), m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) appliedVersions() (map[int]time.Time, error) {
+	rows, err := c.query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func (c Client) MigrationStatus() ([]MigrationStatus, error) {
+	if err := c.ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	migrations, err := c.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies up to steps pending migrations in order, or all of them
+// when steps is 0, and returns the ones it applied. Migrations that need FTS5
+// are left for a build that has it, and a database that already has them
+// can't be used without it, since its triggers would fail every write.
+func (c Client) MigrateUp(steps int) ([]Migration, error) {
+	statuses, err := c.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, status := range statuses {
+		if status.NeedsFTS5 && !c.FullTextSearch() {
+			if status.AppliedAt != nil {
+				return applied, fmt.Errorf("migration %d_%s needs SQLite built with FTS5 (-tags sqlite_fts5)", status.Version, status.Name)
+			}
+			continue
+		}
+		if status.AppliedAt != nil {
+			continue
+		}
+		if steps > 0 && len(applied) == steps {
+			break
+		}
+		if err := c.applyMigration(status.Migration, true); err != nil {
+			return applied, err
+		}
+		applied = append(applied, status.Migration)
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the steps most recently applied migrations and returns
+// the ones it reverted.
+func (c Client) MigrateDown(steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, errors.New("steps must be positive")
+	}
+	statuses, err := c.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []Migration{}
+	for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+		if err := c.applyMigration(statuses[i].Migration, false); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, statuses[i].Migration)
+	}
+	return reverted, nil
+}
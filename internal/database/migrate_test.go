@@ -0,0 +1,200 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func countRows(t *testing.T, c Client, table string) int {
+	t.Helper()
+	var n int
+	err := c.queryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestAdoptLegacyDatabase migrates a database created by the autoMigrate
+// that came before migrations.
+func TestAdoptLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tubely.db")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+	videoID := uuid.New()
+	for _, statement := range []string{
+		`CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			password TEXT NOT NULL,
+			email TEXT UNIQUE NOT NULL
+		)`,
+		`CREATE TABLE refresh_tokens (
+			token TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			revoked_at TIMESTAMP,
+			user_id TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE videos (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			title TEXT NOT NULL,
+			description TEXT,
+			thumbnail_url TEXT,
+			video_url TEXT TEXT,
+			user_id INTEGER,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+		`INSERT INTO users (id, password, email) VALUES ('` + userID.String() + `', 'hash', 'a@example.com')`,
+		`INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES ('token', '` + userID.String() + `', '2099-01-01 00:00:00')`,
+		`INSERT INTO videos (id, title, description, user_id) VALUES ('` + videoID.String() + `', 'old', 'from before', '` + userID.String() + `')`,
+	} {
+		if _, err := legacy.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacy.Close()
+
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatalf("adopting a legacy database: %v", err)
+	}
+	defer c.Close()
+
+	video, err := c.GetVideo(videoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "old" || video.UserID != userID || video.Visibility != VisibilityPrivate {
+		t.Fatalf("unexpected video %+v", video)
+	}
+
+	// The user can still be deleted, although their refresh token's table
+	// doesn't cascade
+	if err := c.DeleteVideo(videoID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteUser(userID); err != nil {
+		t.Fatalf("deleting a legacy user: %v", err)
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	c := newTestClient(t)
+	statuses, err := c.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without FTS5 the search migration is never applied
+	want := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			want++
+		}
+	}
+
+	reverted, err := c.MigrateDown(len(statuses))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != want {
+		t.Fatalf("reverted %d migrations, want %d", len(reverted), want)
+	}
+	applied, err := c.MigrateUp(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != want {
+		t.Fatalf("applied %d migrations, want %d", len(applied), want)
+	}
+}
+
+func TestDeletesCascade(t *testing.T) {
+	c := newTestClient(t)
+	owner, err := c.CreateUser(CreateUserParams{Email: "owner@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grantee, err := c.CreateUser(CreateUserParams{Email: "grantee@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "video", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.CreateJob(CreateJobParams{VideoID: video.ID, SourcePath: "/tmp/source.mp4", ContentType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateUpload(CreateUploadParams{VideoID: video.ID, UserID: owner.ID, Length: 10, FilePath: "/tmp/upload.part"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ShareVideo(ShareVideoParams{VideoID: video.ID, UserID: grantee.ID, Permission: SharePermissionView, GrantedBy: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateShareLink(CreateShareLinkParams{VideoID: video.ID, CreatedBy: owner.ID, TokenHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteVideo(video.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"jobs", "uploads", "video_shares", "share_links"} {
+		if n := countRows(t, c, table); n != 0 {
+			t.Errorf("%s: %d rows left after deleting their video", table, n)
+		}
+	}
+
+	_, err = c.CreateAPIKey(CreateAPIKeyParams{UserID: owner.ID, Name: "ci", Prefix: "tbly_", KeyHash: "hash", Scopes: []string{"read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateRefreshToken(CreateRefreshTokenParams{Token: "token", UserID: owner.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteUser(owner.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"api_keys", "refresh_tokens"} {
+		if n := countRows(t, c, table); n != 0 {
+			t.Errorf("%s: %d rows left after deleting their user", table, n)
+		}
+	}
+}
+
+func TestForeignKeysEnforced(t *testing.T) {
+	c := newTestClient(t)
+	_, err := c.CreateJob(CreateJobParams{VideoID: uuid.New(), SourcePath: "/tmp/source.mp4", ContentType: "video/mp4"})
+	if err == nil {
+		t.Fatal("expected a job for a missing video to be refused")
+	}
+}
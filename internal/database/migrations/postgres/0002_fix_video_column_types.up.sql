@@ -0,0 +1,4 @@
+-- The column types fixed by the SQLite version of this migration were never
+-- wrong on Postgres, so only the index is added here.
+
+CREATE INDEX idx_videos_user_id_created_at ON videos(user_id, created_at);
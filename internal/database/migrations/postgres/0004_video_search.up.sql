@@ -0,0 +1,11 @@
+-- Full-text index over video titles and descriptions. Postgres keeps the
+-- generated column in sync itself, so unlike SQLite no triggers are needed.
+-- The 'simple' configuration matches SQLite's unicode61 tokenizer: no
+-- stemming or stop words.
+
+ALTER TABLE videos ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('simple', coalesce(description, '')), 'B')
+) STORED;
+
+CREATE INDEX idx_videos_search_vector ON videos USING GIN (search_vector);
@@ -0,0 +1,10 @@
+-- Refresh tokens are rotated on every use. Each login starts a family (named
+-- after its first token) that every rotated token inherits, and replaced_by
+-- links a spent token to its successor so a replayed one can be recognised.
+
+ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN replaced_by TEXT;
+
+UPDATE refresh_tokens SET family_id = token WHERE family_id = '';
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
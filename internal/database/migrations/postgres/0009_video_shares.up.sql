@@ -0,0 +1,14 @@
+-- Grants from a video's owner to another user. permission is "view" or
+-- "edit"; a user holds at most one grant per video.
+
+CREATE TABLE video_shares (
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	permission TEXT NOT NULL,
+	granted_by TEXT NOT NULL,
+	PRIMARY KEY (video_id, user_id)
+);
+
+CREATE INDEX idx_video_shares_user_id ON video_shares(user_id);
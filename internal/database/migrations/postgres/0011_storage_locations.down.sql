@@ -0,0 +1,19 @@
+-- Keys go back into the *_url columns, which the previous release read as
+-- keys in the configured store.
+
+UPDATE videos SET thumbnail_url = thumbnail_key WHERE thumbnail_key <> '';
+
+UPDATE videos SET video_url = video_key WHERE video_key <> '';
+
+UPDATE videos SET hls_url = hls_key WHERE hls_key <> '';
+
+ALTER TABLE videos
+	DROP COLUMN thumbnail_backend,
+	DROP COLUMN thumbnail_bucket,
+	DROP COLUMN thumbnail_key,
+	DROP COLUMN video_backend,
+	DROP COLUMN video_bucket,
+	DROP COLUMN video_key,
+	DROP COLUMN hls_backend,
+	DROP COLUMN hls_bucket,
+	DROP COLUMN hls_key;
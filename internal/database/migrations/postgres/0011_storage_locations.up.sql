@@ -0,0 +1,57 @@
+-- Media is referred to by where it's stored rather than by URL, so URLs can
+-- be built, and signed, for each request. A location is the backend ("s3" or
+-- "local"), the bucket (empty for local) and the object key; a key of ''
+-- means the asset isn't stored. The *_url columns are left holding only URLs
+-- of media outside any store.
+--
+-- Existing values are converted: local store URLs and CloudFront URLs become
+-- keys with their backend, and bare keys written since URLs were built at
+-- read time are left without a backend. The app fills in the configured
+-- backend and bucket for any location missing them when it starts.
+
+ALTER TABLE videos
+	ADD COLUMN thumbnail_backend TEXT NOT NULL DEFAULT '',
+	ADD COLUMN thumbnail_bucket TEXT NOT NULL DEFAULT '',
+	ADD COLUMN thumbnail_key TEXT NOT NULL DEFAULT '',
+	ADD COLUMN video_backend TEXT NOT NULL DEFAULT '',
+	ADD COLUMN video_bucket TEXT NOT NULL DEFAULT '',
+	ADD COLUMN video_key TEXT NOT NULL DEFAULT '',
+	ADD COLUMN hls_backend TEXT NOT NULL DEFAULT '',
+	ADD COLUMN hls_bucket TEXT NOT NULL DEFAULT '',
+	ADD COLUMN hls_key TEXT NOT NULL DEFAULT '';
+
+UPDATE videos
+SET thumbnail_backend = 'local', thumbnail_key = regexp_replace(thumbnail_url, '^http://localhost:[0-9]+/assets/', ''), thumbnail_url = NULL
+WHERE thumbnail_url ~ '^http://localhost:[0-9]+/assets/.';
+
+UPDATE videos
+SET thumbnail_backend = 's3', thumbnail_key = regexp_replace(thumbnail_url, '^https://[^/]+\.cloudfront\.net/', ''), thumbnail_url = NULL
+WHERE thumbnail_url ~ '^https://[^/]+\.cloudfront\.net/.';
+
+UPDATE videos
+SET thumbnail_key = thumbnail_url, thumbnail_url = NULL
+WHERE thumbnail_url <> '' AND thumbnail_url NOT LIKE '%://%' AND thumbnail_url NOT LIKE 'data:%';
+
+UPDATE videos
+SET video_backend = 'local', video_key = regexp_replace(video_url, '^http://localhost:[0-9]+/assets/', ''), video_url = NULL
+WHERE video_url ~ '^http://localhost:[0-9]+/assets/.';
+
+UPDATE videos
+SET video_backend = 's3', video_key = regexp_replace(video_url, '^https://[^/]+\.cloudfront\.net/', ''), video_url = NULL
+WHERE video_url ~ '^https://[^/]+\.cloudfront\.net/.';
+
+UPDATE videos
+SET video_key = video_url, video_url = NULL
+WHERE video_url <> '' AND video_url NOT LIKE '%://%' AND video_url NOT LIKE 'data:%';
+
+UPDATE videos
+SET hls_backend = 'local', hls_key = regexp_replace(hls_url, '^http://localhost:[0-9]+/assets/', ''), hls_url = NULL
+WHERE hls_url ~ '^http://localhost:[0-9]+/assets/.';
+
+UPDATE videos
+SET hls_backend = 's3', hls_key = regexp_replace(hls_url, '^https://[^/]+\.cloudfront\.net/', ''), hls_url = NULL
+WHERE hls_url ~ '^https://[^/]+\.cloudfront\.net/.';
+
+UPDATE videos
+SET hls_key = hls_url, hls_url = NULL
+WHERE hls_url <> '' AND hls_url NOT LIKE '%://%' AND hls_url NOT LIKE 'data:%';
@@ -0,0 +1,17 @@
+-- Stored objects waiting to be deleted: media a video no longer uses, kept
+-- for a grace period so URLs already handed out keep working, and deletions
+-- that failed and are retried. delete_after is when the next attempt is due.
+
+CREATE TABLE pending_deletions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	backend TEXT NOT NULL,
+	bucket TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	delete_after TIMESTAMPTZ NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	UNIQUE(backend, bucket, object_key)
+);
+
+CREATE INDEX idx_pending_deletions_delete_after ON pending_deletions(delete_after);
@@ -0,0 +1,27 @@
+-- Processed media is content addressed. Uploads are hashed, and a video
+-- whose source has the same SHA-256 as one already processed shares that
+-- blob instead of being processed and stored again. ref_count is how many
+-- videos use a blob; its objects are deleted when the last one lets go.
+--
+-- jobs.source_sha256 is the hash of a job's input when it was taken on the
+-- way in, and uploads.hash_state the serialized hash of a resumable upload's
+-- bytes so far.
+
+CREATE TABLE blobs (
+	sha256 TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	backend TEXT NOT NULL,
+	bucket TEXT NOT NULL,
+	video_key TEXT NOT NULL,
+	hls_key TEXT NOT NULL,
+	thumbnail_key TEXT NOT NULL DEFAULT '',
+	ref_count INTEGER NOT NULL DEFAULT 0
+);
+
+ALTER TABLE videos ADD COLUMN blob_sha256 TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_videos_blob_sha256 ON videos(blob_sha256);
+
+ALTER TABLE jobs ADD COLUMN source_sha256 TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE uploads ADD COLUMN hash_state TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE videos DROP COLUMN stored_bytes;
+ALTER TABLE users DROP COLUMN quota_videos;
+ALTER TABLE users DROP COLUMN quota_bytes;
@@ -0,0 +1,83 @@
+-- Baseline: the users, refresh_tokens and videos tables as the old
+-- autoMigrate created them, including its mistakes (fixed in 0002), plus
+-- what was built on them before there were migrations. The tables are only
+-- created when missing, so databases created before migrations existed are
+-- adopted.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	user_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+-- Columns added to videos since the tables above were released. They're
+-- added here rather than in the CREATE TABLE so that adopted databases get
+-- them too.
+ALTER TABLE videos ADD COLUMN hls_url TEXT;
+ALTER TABLE videos ADD COLUMN processing_state TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN processing_error TEXT;
+ALTER TABLE videos ADD COLUMN duration_seconds REAL NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN bitrate INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN container TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN video_codec TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN audio_codec TEXT NOT NULL DEFAULT '';
+ALTER TABLE videos ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN frame_rate REAL NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN rotation INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN audio_channels INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN file_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE videos ADD COLUMN aspect_ratio TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	source_path TEXT NOT NULL,
+	source_key TEXT NOT NULL DEFAULT '',
+	content_type TEXT NOT NULL,
+	last_error TEXT,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS uploads (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	upload_length INTEGER NOT NULL,
+	upload_offset INTEGER NOT NULL DEFAULT 0,
+	metadata TEXT NOT NULL DEFAULT '',
+	file_path TEXT NOT NULL,
+	completed_at TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
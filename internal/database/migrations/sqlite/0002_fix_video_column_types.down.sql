@@ -0,0 +1,33 @@
+CREATE TABLE videos_old (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	user_id INTEGER,
+	hls_url TEXT,
+	processing_state TEXT NOT NULL DEFAULT '',
+	processing_error TEXT,
+	duration_seconds REAL NOT NULL DEFAULT 0,
+	bitrate INTEGER NOT NULL DEFAULT 0,
+	container TEXT NOT NULL DEFAULT '',
+	video_codec TEXT NOT NULL DEFAULT '',
+	audio_codec TEXT NOT NULL DEFAULT '',
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	frame_rate REAL NOT NULL DEFAULT 0,
+	rotation INTEGER NOT NULL DEFAULT 0,
+	audio_channels INTEGER NOT NULL DEFAULT 0,
+	file_size INTEGER NOT NULL DEFAULT 0,
+	aspect_ratio TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_old (id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, hls_url, processing_state, processing_error, duration_seconds, bitrate, container, video_codec, audio_codec, width, height, frame_rate, rotation, audio_channels, file_size, aspect_ratio)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, hls_url, processing_state, processing_error, duration_seconds, bitrate, container, video_codec, audio_codec, width, height, frame_rate, rotation, audio_channels, file_size, aspect_ratio
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_old RENAME TO videos;
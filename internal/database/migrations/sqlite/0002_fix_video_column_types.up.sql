@@ -0,0 +1,42 @@
+-- Fix the column types the original videos table got wrong: video_url was
+-- declared "TEXT TEXT" and user_id INTEGER even though it holds a UUID.
+-- SQLite can't alter column types, so the table is rebuilt. Videos without an
+-- owner were never reachable through the API, but rather than dropping them
+-- the migration fails on them (NOT NULL constraint failed: videos_new.user_id)
+-- so they can be given an owner or deleted by hand first.
+
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id TEXT NOT NULL,
+	hls_url TEXT,
+	processing_state TEXT NOT NULL DEFAULT '',
+	processing_error TEXT,
+	duration_seconds REAL NOT NULL DEFAULT 0,
+	bitrate INTEGER NOT NULL DEFAULT 0,
+	container TEXT NOT NULL DEFAULT '',
+	video_codec TEXT NOT NULL DEFAULT '',
+	audio_codec TEXT NOT NULL DEFAULT '',
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	frame_rate REAL NOT NULL DEFAULT 0,
+	rotation INTEGER NOT NULL DEFAULT 0,
+	audio_channels INTEGER NOT NULL DEFAULT 0,
+	file_size INTEGER NOT NULL DEFAULT 0,
+	aspect_ratio TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO videos_new (id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, hls_url, processing_state, processing_error, duration_seconds, bitrate, container, video_codec, audio_codec, width, height, frame_rate, rotation, audio_channels, file_size, aspect_ratio)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, CAST(user_id AS TEXT), hls_url, processing_state, processing_error, duration_seconds, bitrate, container, video_codec, audio_codec, width, height, frame_rate, rotation, audio_channels, file_size, aspect_ratio
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
+
+CREATE INDEX idx_videos_user_id_created_at ON videos(user_id, created_at);
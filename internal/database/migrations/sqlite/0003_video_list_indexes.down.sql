@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_videos_user_id_duration;
+DROP INDEX IF EXISTS idx_videos_user_id_title;
+DROP INDEX IF EXISTS idx_videos_user_id_updated_at;
@@ -0,0 +1,6 @@
+-- Back the sort orders offered by GET /api/videos. created_at is already
+-- covered by idx_videos_user_id_created_at.
+
+CREATE INDEX idx_videos_user_id_updated_at ON videos(user_id, updated_at);
+CREATE INDEX idx_videos_user_id_title ON videos(user_id, title);
+CREATE INDEX idx_videos_user_id_duration ON videos(user_id, duration_seconds);
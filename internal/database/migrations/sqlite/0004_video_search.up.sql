@@ -0,0 +1,34 @@
+-- requires: fts5
+-- Full-text index over video titles and descriptions. It's an FTS5 table,
+-- videos_fts, that stores its own copy of the text keyed by video_id, because
+-- the rowids of videos aren't stable (the table has a TEXT primary key and
+-- has been rebuilt before). Triggers keep it in step with videos.
+--
+-- FTS5 is only there when SQLite is built with the sqlite_fts5 build tag,
+-- which the Makefile sets. Without it this migration stays pending, search
+-- falls back to LIKE, and the first build with FTS5 applies it.
+
+CREATE VIRTUAL TABLE videos_fts USING fts5(
+	video_id UNINDEXED,
+	title,
+	description,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO videos_fts (video_id, title, description)
+SELECT id, title, COALESCE(description, '') FROM videos;
+
+CREATE TRIGGER videos_fts_insert AFTER INSERT ON videos BEGIN
+	INSERT INTO videos_fts (video_id, title, description)
+	VALUES (new.id, new.title, COALESCE(new.description, ''));
+END;
+
+CREATE TRIGGER videos_fts_update AFTER UPDATE OF title, description ON videos BEGIN
+	DELETE FROM videos_fts WHERE video_id = old.id;
+	INSERT INTO videos_fts (video_id, title, description)
+	VALUES (new.id, new.title, COALESCE(new.description, ''));
+END;
+
+CREATE TRIGGER videos_fts_delete AFTER DELETE ON videos BEGIN
+	DELETE FROM videos_fts WHERE video_id = old.id;
+END;
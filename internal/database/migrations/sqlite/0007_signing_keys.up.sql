@@ -0,0 +1,11 @@
+-- Key pairs that sign access tokens. A key is published in the JWKS from
+-- creation, signs new tokens from activates_at until its successor
+-- activates, and is deleted once the tokens it signed have expired.
+
+CREATE TABLE signing_keys (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	activates_at TIMESTAMP NOT NULL,
+	algorithm TEXT NOT NULL,
+	private_key TEXT NOT NULL
+);
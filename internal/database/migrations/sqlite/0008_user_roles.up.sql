@@ -0,0 +1,5 @@
+-- Roles decide what a user can do across the service: admins manage users
+-- and everyone's content, creators upload their own videos, viewers only
+-- watch. Existing users keep uploading as creators.
+
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'creator';
@@ -0,0 +1,16 @@
+-- Grants from a video's owner to another user. permission is "view" or
+-- "edit"; a user holds at most one grant per video.
+
+CREATE TABLE video_shares (
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	permission TEXT NOT NULL,
+	granted_by TEXT NOT NULL,
+	PRIMARY KEY (video_id, user_id),
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_video_shares_user_id ON video_shares(user_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS share_links;
+DROP INDEX IF EXISTS idx_videos_visibility_created_at;
+ALTER TABLE videos DROP COLUMN visibility;
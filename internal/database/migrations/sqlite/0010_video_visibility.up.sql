@@ -0,0 +1,23 @@
+-- Who can watch a video without being granted access: "private" videos only
+-- their owner, admins and users they're shared with; "unlisted" ones anyone
+-- with the link; "public" ones anyone, and they're listed publicly.
+--
+-- share_links give anonymous read access to one video. Only a SHA-256 of the
+-- token is stored.
+
+ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private';
+
+CREATE INDEX idx_videos_visibility_created_at ON videos(visibility, created_at);
+
+CREATE TABLE share_links (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	created_by TEXT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	expires_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_share_links_video_id ON share_links(video_id);
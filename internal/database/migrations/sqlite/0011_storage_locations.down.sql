@@ -0,0 +1,18 @@
+-- Keys go back into the *_url columns, which the previous release read as
+-- keys in the configured store.
+
+UPDATE videos SET thumbnail_url = thumbnail_key WHERE thumbnail_key <> '';
+
+UPDATE videos SET video_url = video_key WHERE video_key <> '';
+
+UPDATE videos SET hls_url = hls_key WHERE hls_key <> '';
+
+ALTER TABLE videos DROP COLUMN thumbnail_backend;
+ALTER TABLE videos DROP COLUMN thumbnail_bucket;
+ALTER TABLE videos DROP COLUMN thumbnail_key;
+ALTER TABLE videos DROP COLUMN video_backend;
+ALTER TABLE videos DROP COLUMN video_bucket;
+ALTER TABLE videos DROP COLUMN video_key;
+ALTER TABLE videos DROP COLUMN hls_backend;
+ALTER TABLE videos DROP COLUMN hls_bucket;
+ALTER TABLE videos DROP COLUMN hls_key;
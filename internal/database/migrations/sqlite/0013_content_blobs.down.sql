@@ -0,0 +1,5 @@
+ALTER TABLE uploads DROP COLUMN hash_state;
+ALTER TABLE jobs DROP COLUMN source_sha256;
+DROP INDEX IF EXISTS idx_videos_blob_sha256;
+ALTER TABLE videos DROP COLUMN blob_sha256;
+DROP TABLE IF EXISTS blobs;
@@ -0,0 +1,15 @@
+-- Users are limited in how much they store. stored_bytes is what a video
+-- counts against its owner's quota: the size of the upload it was last
+-- processed from, whether or not its media is shared with other videos.
+-- Existing videos are charged the size of their processed file.
+--
+-- quota_bytes and quota_videos are an admin's override of the configured
+-- defaults for one user. NULL means the default applies and 0 no limit.
+
+ALTER TABLE users ADD COLUMN quota_bytes INTEGER;
+
+ALTER TABLE users ADD COLUMN quota_videos INTEGER;
+
+ALTER TABLE videos ADD COLUMN stored_bytes INTEGER NOT NULL DEFAULT 0;
+
+UPDATE videos SET stored_bytes = file_size WHERE file_size > 0;
@@ -0,0 +1,14 @@
+-- Token buckets of the rate limiter when it keeps them in the database, so
+-- that every instance of the app shares them. Times are Unix milliseconds,
+-- as buckets refill by fractions of a token and timestamps in SQLite only
+-- keep whole seconds. Full buckets are the same as missing ones and are
+-- deleted once full_at_ms has passed.
+
+CREATE TABLE rate_limits (
+	bucket_key TEXT PRIMARY KEY,
+	tokens REAL NOT NULL,
+	updated_at_ms INTEGER NOT NULL,
+	full_at_ms INTEGER NOT NULL
+);
+
+CREATE INDEX idx_rate_limits_full_at_ms ON rate_limits(full_at_ms);
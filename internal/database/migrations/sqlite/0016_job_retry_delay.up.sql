@@ -0,0 +1,5 @@
+-- Failed jobs wait before they are retried, longer after every attempt, so
+-- a passing problem doesn't use up every attempt at once. Queued jobs are
+-- only claimed once run_after has passed; NULL means right away.
+
+ALTER TABLE jobs ADD COLUMN run_after TIMESTAMP;
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PendingDeletion is a stored object that is to be deleted once DeleteAfter
+// has passed.
+type PendingDeletion struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Attempts  int       `json:"attempts"`
+	LastError *string   `json:"last_error"`
+	QueueDeletionParams
+}
+
+type QueueDeletionParams struct {
+	StorageLocation
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+const pendingDeletionColumns = `
+		id,
+		created_at,
+		attempts,
+		last_error,
+		backend,
+		bucket,
+		object_key,
+		delete_after`
+
+func scanPendingDeletion(row rowScanner) (PendingDeletion, error) {
+	var d PendingDeletion
+	err := row.Scan(
+		&d.ID,
+		&d.CreatedAt,
+		&d.Attempts,
+		&d.LastError,
+		&d.Backend,
+		&d.Bucket,
+		&d.Key,
+		&d.DeleteAfter,
+	)
+	return d, err
+}
+
+// QueueDeletion records that an object is to be deleted. An object that is
+// already queued keeps its place.
+func (c Client) QueueDeletion(params QueueDeletionParams) error {
+	query := `
+	INSERT INTO pending_deletions (
+		id,
+		created_at,
+		backend,
+		bucket,
+		object_key,
+		delete_after
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	ON CONFLICT (backend, bucket, object_key) DO NOTHING
+	`
+	_, err := c.exec(query, uuid.New(), params.Backend, params.Bucket, params.Key, c.timeArg(params.DeleteAfter))
+	return err
+}
+
+// ListPendingDeletions returns the objects queued for deletion in one store,
+// those due first. With due set, only the ones due by now are returned.
+func (c Client) ListPendingDeletions(backend, bucket string, due bool, now time.Time, limit int) ([]PendingDeletion, error) {
+	query := `
+	SELECT` + pendingDeletionColumns + `
+	FROM pending_deletions
+	WHERE backend = ? AND bucket = ?
+	`
+	args := []any{backend, bucket}
+	if due {
+		query += ` AND delete_after <= ?`
+		args = append(args, c.timeArg(now))
+	}
+	query += `
+	ORDER BY delete_after, id
+	LIMIT ?
+	`
+	args = append(args, limit)
+
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []PendingDeletion{}
+	for rows.Next() {
+		d, err := scanPendingDeletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// FailPendingDeletion records a failed attempt and when to try again.
+func (c Client) FailPendingDeletion(id uuid.UUID, deleteErr error, retryAt time.Time) error {
+	query := `
+	UPDATE pending_deletions
+	SET attempts = attempts + 1, last_error = ?, delete_after = ?
+	WHERE id = ?
+	`
+	_, err := c.exec(query, deleteErr.Error(), c.timeArg(retryAt), id)
+	return err
+}
+
+// RemovePendingDeletion forgets an object, once it has been deleted or is in
+// use again.
+func (c Client) RemovePendingDeletion(id uuid.UUID) error {
+	_, err := c.exec(`DELETE FROM pending_deletions WHERE id = ?`, id)
+	return err
+}
+
+// StorageReference is a stored object something in the database refers to.
+type StorageReference struct {
+	// Asset is what refers to it: "thumbnail", "video" or "hls" for a
+	// video's or a blob's media, or "job" for the input of a video job that
+	// hasn't finished.
+	Asset string
+	Key   string
+}
+
+// ListStorageReferences returns every reference to objects in one store.
+func (c Client) ListStorageReferences(backend, bucket string) ([]StorageReference, error) {
+	query := `
+	SELECT 'thumbnail', thumbnail_key FROM videos
+	WHERE thumbnail_key <> '' AND thumbnail_backend = ? AND thumbnail_bucket = ?
+	UNION ALL
+	SELECT 'video', video_key FROM videos
+	WHERE video_key <> '' AND video_backend = ? AND video_bucket = ?
+	UNION ALL
+	SELECT 'hls', hls_key FROM videos
+	WHERE hls_key <> '' AND hls_backend = ? AND hls_bucket = ?
+	UNION ALL
+	SELECT 'video', video_key FROM blobs
+	WHERE video_key <> '' AND backend = ? AND bucket = ?
+	UNION ALL
+	SELECT 'hls', hls_key FROM blobs
+	WHERE hls_key <> '' AND backend = ? AND bucket = ?
+	UNION ALL
+	SELECT 'job', source_key FROM jobs
+	WHERE source_key <> '' AND status IN (?, ?)
+	`
+	rows, err := c.query(query,
+		backend, bucket,
+		backend, bucket,
+		backend, bucket,
+		backend, bucket,
+		backend, bucket,
+		JobStatusQueued, JobStatusRunning,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []StorageReference{}
+	for rows.Next() {
+		var ref StorageReference
+		if err := rows.Scan(&ref.Asset, &ref.Key); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Usage is what a user's videos and unfinished uploads count against their
+// storage quota.
+type Usage struct {
+	Videos int64 `json:"videos"`
+	Bytes  int64 `json:"bytes"`
+	// PendingBytes is the declared length of resumable uploads to the
+	// user's videos that haven't finished but are still being written to,
+	// which are held in reserve.
+	PendingBytes int64 `json:"pending_bytes"`
+}
+
+// GetUserUsage adds up the storage used by a user's videos, whoever
+// uploaded them. Unfinished uploads only count when they've been written to
+// since pendingSince; older ones are about to expire.
+func (c Client) GetUserUsage(userID uuid.UUID, pendingSince time.Time) (Usage, error) {
+	query := `
+	SELECT
+		(SELECT COUNT(*) FROM videos WHERE user_id = ?),
+		(SELECT COALESCE(SUM(stored_bytes), 0) FROM videos WHERE user_id = ?),
+		(SELECT COALESCE(SUM(u.upload_length), 0)
+			FROM uploads u
+			JOIN videos v ON v.id = u.video_id
+			WHERE v.user_id = ? AND u.completed_at IS NULL AND u.updated_at >= ?)
+	`
+	var usage Usage
+	err := c.queryRow(query, userID, userID, userID, c.timeArg(pendingSince)).Scan(&usage.Videos, &usage.Bytes, &usage.PendingBytes)
+	return usage, err
+}
+
+// SetUserQuota overrides a user's storage quotas. A nil quota goes back to
+// the default.
+func (c Client) SetUserQuota(id uuid.UUID, bytes, videos *int64) error {
+	query := `
+		UPDATE users
+		SET quota_bytes = ?, quota_videos = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.exec(query, bytes, videos, id.String())
+	return err
+}
+
+// SetVideoStoredBytes records the size of the upload a video is being
+// processed from, which replaces whatever it counted against the quota
+// before.
+func (c Client) SetVideoStoredBytes(id uuid.UUID, bytes int64) error {
+	query := `
+	UPDATE videos
+	SET stored_bytes = ?
+	WHERE id = ?
+	`
+	_, err := c.exec(query, bytes, id)
+	return err
+}
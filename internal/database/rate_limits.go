@@ -0,0 +1,61 @@
+package database
+
+import (
+	"time"
+)
+
+// UpdateRateLimitBucket replaces the rate limiter's bucket for key with what
+// update returns for it, in a transaction that keeps other instances from
+// updating the bucket at the same time. A bucket that doesn't exist yet is
+// passed to update with a zero updatedAt.
+func (c Client) UpdateRateLimitBucket(key string, now time.Time, update func(tokens float64, updatedAt time.Time) (float64, time.Time)) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Writing before reading takes SQLite's write lock up front, so
+	// concurrent updates wait for each other instead of failing. Postgres
+	// needs the row locked as well.
+	_, err = tx.Exec(c.rebind(`
+	INSERT INTO rate_limits (bucket_key, tokens, updated_at_ms, full_at_ms)
+	VALUES (?, 0, 0, 0)
+	ON CONFLICT (bucket_key) DO NOTHING
+	`), key)
+	if err != nil {
+		return err
+	}
+	query := `SELECT tokens, updated_at_ms FROM rate_limits WHERE bucket_key = ?`
+	if c.dialect == DialectPostgres {
+		query += ` FOR UPDATE`
+	}
+	var tokens float64
+	var updatedAtMS int64
+	err = tx.QueryRow(c.rebind(query), key).Scan(&tokens, &updatedAtMS)
+	if err != nil {
+		return err
+	}
+
+	var updatedAt time.Time
+	if updatedAtMS > 0 {
+		updatedAt = time.UnixMilli(updatedAtMS)
+	}
+	tokens, fullAt := update(tokens, updatedAt)
+
+	_, err = tx.Exec(c.rebind(`
+	UPDATE rate_limits
+	SET tokens = ?, updated_at_ms = ?, full_at_ms = ?
+	WHERE bucket_key = ?
+	`), tokens, now.UnixMilli(), fullAt.UnixMilli(), key)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteFullRateLimitBuckets deletes the buckets that have filled up by now.
+func (c Client) DeleteFullRateLimitBuckets(now time.Time) error {
+	_, err := c.exec(`DELETE FROM rate_limits WHERE full_at_ms <= ?`, now.UnixMilli())
+	return err
+}
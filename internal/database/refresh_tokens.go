@@ -2,35 +2,57 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid, expired or revoked")
+	// ErrRefreshTokenReused means an already rotated token was presented
+	// again, so it has probably been stolen. Its whole family is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
 type RefreshToken struct {
 	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy *string    `json:"-"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	// FamilyID groups a login's token with the ones it was rotated into.
+	// Leave it empty to start a new family.
+	FamilyID string `json:"-"`
+}
+
+// Usable reports whether the token can still be exchanged.
+func (rt RefreshToken) Usable() bool {
+	return rt.Token != "" && rt.RevokedAt == nil && time.Now().Before(rt.ExpiresAt)
 }
 
+const insertRefreshToken = `
+	INSERT INTO refresh_tokens (
+		token,
+		created_at,
+		updated_at,
+		user_id,
+		expires_at,
+		family_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+`
+
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
-	query := `
-		INSERT INTO refresh_tokens (
-			token,
-			created_at,
-			updated_at,
-			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
-	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	if params.FamilyID == "" {
+		params.FamilyID = params.Token
+	}
+	_, err := c.exec(insertRefreshToken, params.Token, params.UserID.String(), params.ExpiresAt, params.FamilyID)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -38,26 +60,95 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	return c.GetRefreshToken(params.Token)
 }
 
+// RotateRefreshToken spends oldToken and issues newToken in its place, in
+// the same family and for the same user. Presenting a token that was already
+// rotated revokes the family and returns ErrRefreshTokenReused.
+func (c Client) RotateRefreshToken(oldToken, newToken string, expiresAt time.Time) (RefreshToken, error) {
+	old, err := c.GetRefreshToken(oldToken)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if old.ReplacedBy != nil {
+		if err := c.RevokeRefreshTokenFamily(old.FamilyID); err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+	if !old.Usable() {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+
+	// Claim the old token with a conditional update so that two concurrent
+	// refreshes can't both succeed; the loser is treated as a replay. The
+	// new token is inserted in the same transaction, so a failed insert
+	// doesn't leave the old token spent with nothing to replace it.
+	tx, err := c.db.Begin()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(c.rebind(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, replaced_by = ?
+		WHERE token = ? AND revoked_at IS NULL
+	`), newToken, oldToken)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if claimed == 0 {
+		tx.Rollback()
+		if err := c.RevokeRefreshTokenFamily(old.FamilyID); err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(c.rebind(insertRefreshToken), newToken, old.UserID.String(), expiresAt, old.FamilyID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+	return c.GetRefreshToken(newToken)
+}
+
 func (c Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
-		SET revoked_at = CURRENT_TIMESTAMP
-		WHERE token = ?
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, token)
+	return err
+}
+
+// RevokeRefreshTokenFamily revokes every token descended from the same login.
+func (c Client) RevokeRefreshTokenFamily(familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.exec(query, familyID)
 	return err
 }
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	err := c.queryRow(query, token).
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.FamilyID, &rt.ReplacedBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -78,6 +169,6 @@ func (c Client) DeleteRefreshToken(token string) error {
 		DELETE FROM refresh_tokens
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.exec(query, token)
 	return err
 }
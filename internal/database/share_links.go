@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink gives anyone holding its token read access to one video.
+type ShareLink struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreateShareLinkParams
+}
+
+type CreateShareLinkParams struct {
+	VideoID   uuid.UUID  `json:"video_id"`
+	CreatedBy uuid.UUID  `json:"created_by"`
+	TokenHash string     `json:"-"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Usable reports whether the link still grants access.
+func (l ShareLink) Usable() bool {
+	if l.RevokedAt != nil {
+		return false
+	}
+	return l.ExpiresAt == nil || time.Now().Before(*l.ExpiresAt)
+}
+
+const shareLinkColumns = `
+		id,
+		created_at,
+		revoked_at,
+		video_id,
+		created_by,
+		token_hash,
+		expires_at`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(
+		&link.ID,
+		&link.CreatedAt,
+		&link.RevokedAt,
+		&link.VideoID,
+		&link.CreatedBy,
+		&link.TokenHash,
+		&link.ExpiresAt,
+	)
+	return link, err
+}
+
+func (c Client) CreateShareLink(params CreateShareLinkParams) (ShareLink, error) {
+	id := uuid.New()
+	var expiresAt *time.Time
+	if params.ExpiresAt != nil {
+		utc := params.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	query := `
+	INSERT INTO share_links (
+		id,
+		created_at,
+		video_id,
+		created_by,
+		token_hash,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.exec(query, id, params.VideoID, params.CreatedBy, params.TokenHash, expiresAt)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return c.GetShareLink(id)
+}
+
+// GetShareLink returns a zero ShareLink if there is none with id.
+func (c Client) GetShareLink(id uuid.UUID) (ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE id = ?
+	`
+	link, err := scanShareLink(c.queryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareLink{}, nil
+	}
+	return link, err
+}
+
+// GetShareLinkByHash returns a zero ShareLink if no link has the token hash.
+func (c Client) GetShareLinkByHash(tokenHash string) (ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE token_hash = ?
+	`
+	link, err := scanShareLink(c.queryRow(query, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareLink{}, nil
+	}
+	return link, err
+}
+
+// ListShareLinks returns every link to a video, newest first.
+func (c Client) ListShareLinks(videoID uuid.UUID) ([]ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE video_id = ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (c Client) RevokeShareLink(id uuid.UUID) error {
+	query := `
+	UPDATE share_links
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, id)
+	return err
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"time"
+)
+
+type SigningKey struct {
+	CreatedAt time.Time `json:"created_at"`
+	CreateSigningKeyParams
+}
+
+type CreateSigningKeyParams struct {
+	ID          string    `json:"id"`
+	ActivatesAt time.Time `json:"activates_at"`
+	Algorithm   string    `json:"algorithm"`
+	PrivateKey  string    `json:"-"`
+}
+
+func (c Client) CreateSigningKey(params CreateSigningKeyParams) error {
+	query := `
+	INSERT INTO signing_keys (
+		id,
+		created_at,
+		activates_at,
+		algorithm,
+		private_key
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.exec(query, params.ID, params.ActivatesAt.UTC(), params.Algorithm, params.PrivateKey)
+	return err
+}
+
+// ListSigningKeys returns every signing key, oldest activation first.
+func (c Client) ListSigningKeys() ([]SigningKey, error) {
+	query := `
+	SELECT id, created_at, activates_at, algorithm, private_key
+	FROM signing_keys
+	ORDER BY activates_at, id
+	`
+	rows, err := c.query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var key SigningKey
+		err := rows.Scan(&key.ID, &key.CreatedAt, &key.ActivatesAt, &key.Algorithm, &key.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) DeleteSigningKey(id string) error {
+	query := `
+	DELETE FROM signing_keys
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload tracks a resumable upload whose bytes are being assembled on disk.
+type Upload struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Offset      int64      `json:"offset"`
+	CompletedAt *time.Time `json:"completed_at"`
+	// HashState is the serialized SHA-256 of the bytes up to Offset, or
+	// empty when it isn't known.
+	HashState string `json:"-"`
+	CreateUploadParams
+}
+
+type CreateUploadParams struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	UserID   uuid.UUID `json:"user_id"`
+	Length   int64     `json:"length"`
+	Metadata string    `json:"metadata"`
+	FilePath string    `json:"-"`
+}
+
+func (c Client) CreateUpload(params CreateUploadParams) (Upload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO uploads (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		metadata,
+		file_path
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.exec(query, id, params.VideoID, params.UserID, params.Length, params.Metadata, params.FilePath)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	return c.GetUpload(id)
+}
+
+const uploadColumns = `
+		id,
+		created_at,
+		updated_at,
+		upload_offset,
+		completed_at,
+		video_id,
+		user_id,
+		upload_length,
+		metadata,
+		file_path,
+		hash_state`
+
+func scanUpload(row rowScanner) (Upload, error) {
+	var upload Upload
+	err := row.Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.Offset,
+		&upload.CompletedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.Length,
+		&upload.Metadata,
+		&upload.FilePath,
+		&upload.HashState,
+	)
+	return upload, err
+}
+
+func (c Client) GetUpload(id uuid.UUID) (Upload, error) {
+	query := `
+	SELECT` + uploadColumns + `
+	FROM uploads
+	WHERE id = ?
+	`
+	upload, err := scanUpload(c.queryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Upload{}, nil
+	}
+	return upload, err
+}
+
+// ListExpiredUploads returns the unfinished uploads that haven't changed
+// since before.
+func (c Client) ListExpiredUploads(before time.Time) ([]Upload, error) {
+	query := `
+	SELECT` + uploadColumns + `
+	FROM uploads
+	WHERE completed_at IS NULL AND updated_at < ?
+	ORDER BY updated_at
+	`
+	rows, err := c.query(query, c.timeArg(before))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// UpdateUploadOffset moves an upload from offset from to offset to. It
+// reports false, changing nothing, when the upload isn't at from anymore
+// because another request got there first.
+func (c Client) UpdateUploadOffset(id uuid.UUID, from, to int64, hashState string) (bool, error) {
+	query := `
+	UPDATE uploads
+	SET upload_offset = ?, hash_state = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND upload_offset = ? AND completed_at IS NULL
+	`
+	result, err := c.exec(query, to, hashState, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (c Client) CompleteUpload(id uuid.UUID) error {
+	query := `
+	UPDATE uploads
+	SET completed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+func (c Client) DeleteUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM uploads
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,23 +13,35 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Role      string    `json:"role"`
+	// QuotaBytes and QuotaVideos override the default storage quotas for
+	// the user when set. Zero means no limit.
+	QuotaBytes  *int64 `json:"quota_bytes"`
+	QuotaVideos *int64 `json:"quota_videos"`
 	CreateUserParams
 }
 
 type CreateUserParams struct {
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"-"`
 }
 
+// GetUsers returns every user, oldest first.
 func (c Client) GetUsers() ([]User, error) {
 	query := `
 		SELECT
 			id,
-			email
+			created_at,
+			updated_at,
+			email,
+			role,
+			quota_bytes,
+			quota_videos
 		FROM users
+		ORDER BY created_at, id
 	`
 
-	rows, err := c.db.Query(query)
+	rows, err := c.query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -38,7 +51,7 @@ func (c Client) GetUsers() ([]User, error) {
 	for rows.Next() {
 		var user User
 		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
+		if err := rows.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Role, &user.QuotaBytes, &user.QuotaVideos); err != nil {
 			return nil, err
 		}
 		user.ID, err = uuid.Parse(id)
@@ -48,18 +61,18 @@ func (c Client) GetUsers() ([]User, error) {
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, role, quota_bytes, quota_videos
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.queryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role, &user.QuotaBytes, &user.QuotaVideos)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -73,9 +86,11 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 	return user, nil
 }
 
+// GetUserByRefreshToken returns the owner of a refresh token, or
+// ErrRefreshTokenInvalid if the token is unknown, expired or revoked.
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.password, u.role, u.quota_bytes, u.quota_videos, rt.expires_at, rt.revoked_at
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
@@ -83,13 +98,18 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	var expiresAt time.Time
+	var revokedAt *time.Time
+	err := c.queryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password, &user.Role, &user.QuotaBytes, &user.QuotaVideos, &expiresAt, &revokedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+			return nil, ErrRefreshTokenInvalid
 		}
 		return nil, err
 	}
+	if revokedAt != nil || !time.Now().Before(expiresAt) {
+		return nil, ErrRefreshTokenInvalid
+	}
 	user.ID, err = uuid.Parse(id)
 	if err != nil {
 		return nil, err
@@ -107,7 +127,7 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 		VALUES
 		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	_, err := c.exec(query, id.String(), params.Email, params.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -117,13 +137,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, role, quota_bytes, quota_videos
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.queryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role, &user.QuotaBytes, &user.QuotaVideos)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -137,11 +157,30 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
+func (c Client) SetUserRole(id uuid.UUID, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.exec(query, role, id.String())
+	return err
+}
+
+// DeleteUser deletes a user and their credentials and unfinished uploads.
+// Their videos must be deleted first, along with the media they point to.
 func (c Client) DeleteUser(id uuid.UUID) error {
+	// Everything else the user owns cascades, but a SQLite refresh_tokens
+	// table from before migrations references users without ON DELETE
+	// CASCADE
+	_, err := c.exec("DELETE FROM refresh_tokens WHERE user_id = ?", id.String())
+	if err != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", err)
+	}
 	query := `
 		DELETE FROM users
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err = c.exec(query, id.String())
 	return err
 }
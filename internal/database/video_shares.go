@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SharePermission string
+
+const (
+	SharePermissionView SharePermission = "view"
+	SharePermissionEdit SharePermission = "edit"
+)
+
+func (p SharePermission) Valid() bool {
+	return p == SharePermissionView || p == SharePermissionEdit
+}
+
+// VideoShare grants a user other than the owner access to a video.
+type VideoShare struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Email is the grantee's, for display.
+	Email string `json:"email"`
+	ShareVideoParams
+}
+
+type ShareVideoParams struct {
+	VideoID    uuid.UUID       `json:"video_id"`
+	UserID     uuid.UUID       `json:"user_id"`
+	Permission SharePermission `json:"permission"`
+	GrantedBy  uuid.UUID       `json:"granted_by"`
+}
+
+const videoShareColumns = `
+		s.video_id,
+		s.user_id,
+		s.created_at,
+		s.updated_at,
+		s.permission,
+		s.granted_by,
+		u.email`
+
+func scanVideoShare(row rowScanner) (VideoShare, error) {
+	var share VideoShare
+	err := row.Scan(
+		&share.VideoID,
+		&share.UserID,
+		&share.CreatedAt,
+		&share.UpdatedAt,
+		&share.Permission,
+		&share.GrantedBy,
+		&share.Email,
+	)
+	return share, err
+}
+
+// ShareVideo grants a user access to a video, replacing the permission of
+// any grant they already hold.
+func (c Client) ShareVideo(params ShareVideoParams) (VideoShare, error) {
+	query := `
+	INSERT INTO video_shares (
+		video_id,
+		user_id,
+		created_at,
+		updated_at,
+		permission,
+		granted_by
+	) VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+	ON CONFLICT (video_id, user_id) DO UPDATE SET
+		permission = excluded.permission,
+		granted_by = excluded.granted_by,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.exec(query, params.VideoID, params.UserID, params.Permission, params.GrantedBy)
+	if err != nil {
+		return VideoShare{}, err
+	}
+	return c.GetVideoShare(params.VideoID, params.UserID)
+}
+
+// GetVideoShare returns the grant userID holds on videoID, or a zero
+// VideoShare if there is none.
+func (c Client) GetVideoShare(videoID, userID uuid.UUID) (VideoShare, error) {
+	query := `
+	SELECT` + videoShareColumns + `
+	FROM video_shares s
+	JOIN users u ON u.id = s.user_id
+	WHERE s.video_id = ? AND s.user_id = ?
+	`
+	share, err := scanVideoShare(c.queryRow(query, videoID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoShare{}, nil
+	}
+	return share, err
+}
+
+// ListVideoShares returns every grant on a video, oldest first.
+func (c Client) ListVideoShares(videoID uuid.UUID) ([]VideoShare, error) {
+	query := `
+	SELECT` + videoShareColumns + `
+	FROM video_shares s
+	JOIN users u ON u.id = s.user_id
+	WHERE s.video_id = ?
+	ORDER BY s.created_at, s.user_id
+	`
+	rows, err := c.query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []VideoShare{}
+	for rows.Next() {
+		share, err := scanVideoShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func (c Client) DeleteVideoShare(videoID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM video_shares
+	WHERE video_id = ? AND user_id = ?
+	`
+	_, err := c.exec(query, videoID, userID)
+	return err
+}
+
+// accessibleVideosCondition restricts a query on videos to the ones userID
+// owns, and also those shared with them when includeShared is set. alias
+// qualifies the videos columns and may be empty.
+func accessibleVideosCondition(alias string, userID uuid.UUID, includeShared bool) (string, []any) {
+	prefix := ""
+	if alias != "" {
+		prefix = alias + "."
+	}
+	if !includeShared {
+		return prefix + "user_id = ?", []any{userID}
+	}
+	condition := "(" + prefix + "user_id = ? OR " + prefix + "id IN (SELECT video_id FROM video_shares WHERE user_id = ?))"
+	return condition, []any{userID, userID}
+}
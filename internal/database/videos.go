@@ -3,17 +3,66 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ProcessingState string
+
+const (
+	ProcessingStateNone       ProcessingState = ""
+	ProcessingStateUploaded   ProcessingState = "uploaded"
+	ProcessingStateProcessing ProcessingState = "processing"
+	ProcessingStateReady      ProcessingState = "ready"
+	ProcessingStateFailed     ProcessingState = "failed"
+)
+
+// Visibility decides who can watch a video without being granted access.
+type Visibility string
+
+const (
+	VisibilityPrivate  Visibility = "private"
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPublic   Visibility = "public"
+)
+
+func (v Visibility) Valid() bool {
+	return v == VisibilityPrivate || v == VisibilityUnlisted || v == VisibilityPublic
+}
+
+// StorageLocation is where a stored object lives. Bucket is empty for the
+// local backend.
+type StorageLocation struct {
+	Backend string
+	Bucket  string
+	Key     string
+}
+
+// Video refers to stored media by location. The *URL fields only come from
+// the database for media outside any store; for stored media they are
+// filled in from the locations when the video is served. Processed media
+// shared with other videos of the same upload is named by BlobSHA256.
+// StoredBytes is what the video counts against its owner's storage quota.
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                uuid.UUID        `json:"id"`
+	CreatedAt         time.Time        `json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
+	ThumbnailURL      *string          `json:"thumbnail_url"`
+	VideoURL          *string          `json:"video_url"`
+	HLSURL            *string          `json:"hls_url"`
+	ThumbnailLocation *StorageLocation `json:"-"`
+	VideoLocation     *StorageLocation `json:"-"`
+	HLSLocation       *StorageLocation `json:"-"`
+	BlobSHA256        string           `json:"-"`
+	StoredBytes       int64            `json:"stored_bytes"`
+	ProcessingState   ProcessingState  `json:"processing_state"`
+	ProcessingError   *string          `json:"processing_error"`
+	Metadata          MediaMetadata    `json:"metadata"`
+	Visibility        Visibility       `json:"visibility"`
 	CreateVideoParams
 }
 
@@ -23,9 +72,24 @@ type CreateVideoParams struct {
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// MediaMetadata is what ffprobe tells us about a processed video.
+type MediaMetadata struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Bitrate         int64   `json:"bitrate"`
+	Container       string  `json:"container"`
+	VideoCodec      string  `json:"video_codec"`
+	AudioCodec      string  `json:"audio_codec"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	FrameRate       float64 `json:"frame_rate"`
+	Rotation        int     `json:"rotation"`
+	AudioChannels   int     `json:"audio_channels"`
+	FileSize        int64   `json:"file_size"`
+	AspectRatio     string  `json:"aspect_ratio"`
+}
+
+// videoColumns lists the columns read by scanVideo, in order.
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,13 +97,132 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		hls_url,
+		processing_state,
+		processing_error,
+		duration_seconds,
+		bitrate,
+		container,
+		video_codec,
+		audio_codec,
+		width,
+		height,
+		frame_rate,
+		rotation,
+		audio_channels,
+		file_size,
+		aspect_ratio,
+		user_id,
+		visibility,
+		thumbnail_backend,
+		thumbnail_bucket,
+		thumbnail_key,
+		video_backend,
+		video_bucket,
+		video_key,
+		hls_backend,
+		hls_bucket,
+		hls_key,
+		blob_sha256,
+		stored_bytes`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := scanVideoInto(row, &video)
+	return video, err
+}
+
+// scanVideoInto scans videoColumns into video, followed by any extra columns
+// the query selects after them.
+func scanVideoInto(row rowScanner, video *Video, extra ...any) error {
+	var thumbnail, videoFile, hls StorageLocation
+	dest := []any{
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.HLSURL,
+		&video.ProcessingState,
+		&video.ProcessingError,
+		&video.Metadata.DurationSeconds,
+		&video.Metadata.Bitrate,
+		&video.Metadata.Container,
+		&video.Metadata.VideoCodec,
+		&video.Metadata.AudioCodec,
+		&video.Metadata.Width,
+		&video.Metadata.Height,
+		&video.Metadata.FrameRate,
+		&video.Metadata.Rotation,
+		&video.Metadata.AudioChannels,
+		&video.Metadata.FileSize,
+		&video.Metadata.AspectRatio,
+		&video.UserID,
+		&video.Visibility,
+		&thumbnail.Backend,
+		&thumbnail.Bucket,
+		&thumbnail.Key,
+		&videoFile.Backend,
+		&videoFile.Bucket,
+		&videoFile.Key,
+		&hls.Backend,
+		&hls.Bucket,
+		&hls.Key,
+		&video.BlobSHA256,
+		&video.StoredBytes,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return err
+	}
+	video.ThumbnailLocation = storedLocation(thumbnail)
+	video.VideoLocation = storedLocation(videoFile)
+	video.HLSLocation = storedLocation(hls)
+	return nil
+}
+
+// storedLocation returns nil for the empty location of an asset that isn't
+// stored.
+func storedLocation(loc StorageLocation) *StorageLocation {
+	if loc.Key == "" {
+		return nil
+	}
+	return &loc
+}
+
+// locationArgs returns the backend, bucket and key column values for loc.
+func locationArgs(loc *StorageLocation) []any {
+	if loc == nil {
+		return []any{"", "", ""}
+	}
+	return []any{loc.Backend, loc.Bucket, loc.Key}
+}
+
+// qualifiedVideoColumns is videoColumns prefixed with a table alias, for
+// queries that join videos with tables sharing its column names.
+func qualifiedVideoColumns(alias string) string {
+	columns := strings.Split(videoColumns, ",")
+	for i, column := range columns {
+		columns[i] = strings.Replace(column, strings.TrimSpace(column), alias+"."+strings.TrimSpace(column), 1)
+	}
+	return strings.Join(columns, ",")
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
 	`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.query(query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -47,17 +230,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -78,7 +252,7 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		user_id
 	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.exec(query, id, params.Title, params.Description, params.UserID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +262,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.queryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -129,27 +286,204 @@ func (c Client) UpdateVideo(video Video) error {
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
+		hls_url = ?,
+		thumbnail_backend = ?,
+		thumbnail_bucket = ?,
+		thumbnail_key = ?,
+		video_backend = ?,
+		video_bucket = ?,
+		video_key = ?,
+		hls_backend = ?,
+		hls_bucket = ?,
+		hls_key = ?,
 		user_id = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
-		query,
+	args := []any{
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
-		video.UserID,
-		video.ID,
+		&video.HLSURL,
+	}
+	args = append(args, locationArgs(video.ThumbnailLocation)...)
+	args = append(args, locationArgs(video.VideoLocation)...)
+	args = append(args, locationArgs(video.HLSLocation)...)
+	args = append(args, video.UserID, video.ID)
+	_, err := c.exec(query, args...)
+	return err
+}
+
+// AssignStorageLocations gives stored media without a backend the backend
+// and bucket given, as does S3 media without a bucket when backend is S3.
+// Those are locations converted from data written before locations were
+// stored. It returns how many assets changed.
+func (c Client) AssignStorageLocations(backend, bucket string) (int64, error) {
+	var changed int64
+	for _, asset := range []string{"thumbnail", "video", "hls"} {
+		query := fmt.Sprintf(`
+		UPDATE videos
+		SET %[1]s_backend = ?, %[1]s_bucket = ?
+		WHERE %[1]s_key <> ''
+			AND (%[1]s_backend = '' OR (%[1]s_backend = 's3' AND %[1]s_bucket = '' AND ? = 's3'))
+		`, asset)
+		result, err := c.exec(query, backend, bucket, backend)
+		if err != nil {
+			return changed, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return changed, err
+		}
+		changed += n
+	}
+	return changed, nil
+}
+
+func (c Client) SetVideoMetadata(id uuid.UUID, meta MediaMetadata) error {
+	query := `
+	UPDATE videos
+	SET
+		duration_seconds = ?,
+		bitrate = ?,
+		container = ?,
+		video_codec = ?,
+		audio_codec = ?,
+		width = ?,
+		height = ?,
+		frame_rate = ?,
+		rotation = ?,
+		audio_channels = ?,
+		file_size = ?,
+		aspect_ratio = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(
+		query,
+		meta.DurationSeconds,
+		meta.Bitrate,
+		meta.Container,
+		meta.VideoCodec,
+		meta.AudioCodec,
+		meta.Width,
+		meta.Height,
+		meta.FrameRate,
+		meta.Rotation,
+		meta.AudioChannels,
+		meta.FileSize,
+		meta.AspectRatio,
+		id,
+	)
+	return err
+}
+
+// SetVideoDetails changes a video's title and description, leaving the media
+// fields UpdateVideo writes alone so edits can't race with processing.
+func (c Client) SetVideoDetails(id uuid.UUID, title, description string) error {
+	query := `
+	UPDATE videos
+	SET
+		title = ?,
+		description = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, title, description, id)
+	return err
+}
+
+func (c Client) SetVideoVisibility(id uuid.UUID, visibility Visibility) error {
+	query := `
+	UPDATE videos
+	SET
+		visibility = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, visibility, id)
+	return err
+}
+
+// IsPublicMedia reports whether the object at key in one store belongs to a
+// public video: it is the video's thumbnail, MP4 or HLS playlist, or it is
+// below the MP4's key without its extension, where the renditions and
+// thumbnails processed from the MP4 are stored.
+func (c Client) IsPublicMedia(backend, bucket, key string) (bool, error) {
+	query := `
+	SELECT COUNT(*) FROM videos
+	WHERE visibility = ? AND (
+		(thumbnail_key = ? AND thumbnail_backend = ? AND thumbnail_bucket = ?) OR
+		(video_key = ? AND video_backend = ? AND video_bucket = ?) OR
+		(hls_key = ? AND hls_backend = ? AND hls_bucket = ?)
 	)
+	`
+	var count int
+	err := c.queryRow(query, VisibilityPublic,
+		key, backend, bucket,
+		key, backend, bucket,
+		key, backend, bucket,
+	).Scan(&count)
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	// MP4s that could have been processed into key: one of its parent
+	// directories followed by an extension
+	conditions := []string{}
+	args := []any{VisibilityPublic, backend, bucket}
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		conditions = append(conditions, "substr(video_key, 1, ?) = ?")
+		args = append(args, len(dir)+1, dir+".")
+	}
+	if len(conditions) == 0 {
+		return false, nil
+	}
+	query = `
+	SELECT video_key FROM videos
+	WHERE visibility = ? AND video_backend = ? AND video_bucket = ? AND (` + strings.Join(conditions, " OR ") + `)
+	`
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var videoKey string
+		if err := rows.Scan(&videoKey); err != nil {
+			return false, err
+		}
+		if strings.HasPrefix(key, strings.TrimSuffix(videoKey, path.Ext(videoKey))+"/") {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// SetVideoProcessingState is kept separate from UpdateVideo so that metadata
+// edits made while a video is processing can't overwrite its state.
+func (c Client) SetVideoProcessingState(id uuid.UUID, state ProcessingState, processingErr *string) error {
+	query := `
+	UPDATE videos
+	SET
+		processing_state = ?,
+		processing_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, state, processingErr, id)
 	return err
 }
 
+// DeleteVideo deletes a video. Its jobs, uploads, grants and share links go
+// with it.
 func (c Client) DeleteVideo(id uuid.UUID) error {
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := c.exec(query, id)
 	return err
 }
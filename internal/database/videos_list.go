@@ -0,0 +1,218 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VideoSort string
+
+const (
+	VideoSortCreated  VideoSort = "created"
+	VideoSortUpdated  VideoSort = "updated"
+	VideoSortTitle    VideoSort = "title"
+	VideoSortDuration VideoSort = "duration"
+)
+
+var videoSortColumns = map[VideoSort]string{
+	VideoSortCreated:  "created_at",
+	VideoSortUpdated:  "updated_at",
+	VideoSortTitle:    "title",
+	VideoSortDuration: "duration_seconds",
+}
+
+func (s VideoSort) Valid() bool {
+	_, ok := videoSortColumns[s]
+	return ok
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// VideoCursor points just past the last video of a page. It carries the sort
+// key of that video rather than an offset, so pages stay stable while videos
+// are added or removed.
+type VideoCursor struct {
+	Sort     VideoSort `json:"s"`
+	Desc     bool      `json:"d"`
+	Time     time.Time `json:"t"`
+	Title    string    `json:"n,omitempty"`
+	Duration float64   `json:"l,omitempty"`
+	ID       uuid.UUID `json:"i"`
+}
+
+// Encode returns the cursor in the opaque form handed to API clients.
+func (vc VideoCursor) Encode() string {
+	data, _ := json.Marshal(vc)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeVideoCursor(s string) (VideoCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	var vc VideoCursor
+	if err := json.Unmarshal(data, &vc); err != nil || !vc.Sort.Valid() || vc.ID == uuid.Nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	return vc, nil
+}
+
+func videoCursorFor(video Video, sort VideoSort, desc bool) VideoCursor {
+	vc := VideoCursor{Sort: sort, Desc: desc, ID: video.ID}
+	switch sort {
+	case VideoSortCreated:
+		vc.Time = video.CreatedAt
+	case VideoSortUpdated:
+		vc.Time = video.UpdatedAt
+	case VideoSortTitle:
+		vc.Title = video.Title
+	case VideoSortDuration:
+		vc.Duration = video.Metadata.DurationSeconds
+	}
+	return vc
+}
+
+type ListVideosParams struct {
+	UserID uuid.UUID
+	Limit  int
+	Sort   VideoSort
+	Desc   bool
+	// After continues from a previous page. Its sort and direction must
+	// match the ones above.
+	After *VideoCursor
+
+	// IncludeShared adds the videos other users have shared with UserID.
+	IncludeShared bool
+	// Public lists every user's public videos instead of UserID's.
+	Public bool
+
+	HasVideo      *bool
+	HasThumbnail  *bool
+	AspectRatio   string
+	Visibility    Visibility
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// ListVideos returns one page of a user's videos, plus a cursor for the next
+// page when there is one.
+func (c Client) ListVideos(params ListVideosParams) ([]Video, *VideoCursor, error) {
+	sortColumn, ok := videoSortColumns[params.Sort]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort %q", params.Sort)
+	}
+	if params.Limit <= 0 {
+		return nil, nil, errors.New("limit must be positive")
+	}
+
+	condition, args := accessibleVideosCondition("", params.UserID, params.IncludeShared)
+	if params.Public {
+		condition, args = "visibility = ?", []any{VisibilityPublic}
+	}
+	where := []string{condition}
+
+	if params.HasVideo != nil {
+		where = append(where, presenceCondition("video", *params.HasVideo))
+	}
+	if params.HasThumbnail != nil {
+		where = append(where, presenceCondition("thumbnail", *params.HasThumbnail))
+	}
+	if params.AspectRatio != "" {
+		where = append(where, "aspect_ratio = ?")
+		args = append(args, params.AspectRatio)
+	}
+	if params.Visibility != "" {
+		where = append(where, "visibility = ?")
+		args = append(args, params.Visibility)
+	}
+	if params.CreatedAfter != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, c.timeArg(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, c.timeArg(*params.CreatedBefore))
+	}
+
+	direction, comparison := "ASC", ">"
+	if params.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if after := params.After; after != nil {
+		if after.Sort != params.Sort || after.Desc != params.Desc {
+			return nil, nil, ErrInvalidCursor
+		}
+		var value any
+		switch after.Sort {
+		case VideoSortCreated, VideoSortUpdated:
+			value = c.timeArg(after.Time)
+		case VideoSortTitle:
+			value = after.Title
+		case VideoSortDuration:
+			value = after.Duration
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (?, ?)", sortColumn, comparison))
+		args = append(args, value, after.ID)
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + sortColumn + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
+	`
+	// One extra row tells us whether there is another page.
+	args = append(args, params.Limit+1)
+
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(videos) <= params.Limit {
+		return videos, nil, nil
+	}
+	videos = videos[:params.Limit]
+	next := videoCursorFor(videos[len(videos)-1], params.Sort, params.Desc)
+	return videos, &next, nil
+}
+
+// presenceCondition matches videos that have, or lack, an asset, whether
+// stored or at an outside URL.
+func presenceCondition(asset string, present bool) string {
+	if present {
+		return fmt.Sprintf("(%[1]s_key <> '' OR COALESCE(%[1]s_url, '') <> '')", asset)
+	}
+	return fmt.Sprintf("(%[1]s_key = '' AND COALESCE(%[1]s_url, '') = '')", asset)
+}
+
+// timeArg converts t for comparison against a timestamp column. SQLite keeps
+// CURRENT_TIMESTAMP values as "YYYY-MM-DD HH:MM:SS" text in UTC and compares
+// them as strings, so times have to be given in exactly that shape.
+func (c Client) timeArg(t time.Time) any {
+	if c.dialect == DialectSQLite {
+		return t.UTC().Format(time.DateTime)
+	}
+	return t
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// Search snippets are built with these control characters around matches and
+// only turned into markup after escaping, so user text can't inject HTML.
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+var ErrEmptySearch = errors.New("search query has no searchable terms")
+
+type VideoSearchResult struct {
+	Video
+	// TitleHighlight and DescriptionSnippet are HTML-escaped, with matched
+	// terms wrapped in <mark>.
+	TitleHighlight     string  `json:"title_highlight"`
+	DescriptionSnippet string  `json:"description_snippet"`
+	Rank               float64 `json:"rank"`
+}
+
+type SearchVideosParams struct {
+	UserID uuid.UUID
+	// IncludeShared adds the videos other users have shared with UserID.
+	IncludeShared bool
+	Query         string
+	Limit         int
+}
+
+// SearchVideos finds the user's videos whose title or description contain
+// every term of the query, treating each term as a prefix. Title matches
+// rank above description matches. Without a full-text index, see
+// searchVideosLike.
+func (c Client) SearchVideos(params SearchVideosParams) ([]VideoSearchResult, error) {
+	terms := searchTerms(params.Query)
+	if len(terms) == 0 {
+		return nil, ErrEmptySearch
+	}
+	if params.Limit <= 0 {
+		return nil, errors.New("limit must be positive")
+	}
+
+	if !c.FullTextSearch() {
+		return c.searchVideosLike(params, terms)
+	}
+
+	accessible, accessibleArgs := accessibleVideosCondition("v", params.UserID, params.IncludeShared)
+
+	var query string
+	var match string
+	if c.dialect == DialectPostgres {
+		for i, term := range terms {
+			terms[i] = term + ":*"
+		}
+		match = strings.Join(terms, " & ")
+		query = `
+		SELECT` + qualifiedVideoColumns("v") + `,
+			ts_headline('simple', v.title, q, 'HighlightAll=true, StartSel=` + highlightStart + `, StopSel=` + highlightEnd + `'),
+			ts_headline('simple', COALESCE(v.description, ''), q, 'MaxWords=20, MinWords=8, StartSel=` + highlightStart + `, StopSel=` + highlightEnd + `'),
+			ts_rank(v.search_vector, q) AS rank
+		FROM videos v, to_tsquery('simple', ?) q
+		WHERE v.search_vector @@ q AND ` + accessible + `
+		ORDER BY rank DESC, v.created_at DESC
+		LIMIT ?
+		`
+	} else {
+		for i, term := range terms {
+			terms[i] = `"` + term + `"*`
+		}
+		match = strings.Join(terms, " ")
+		// bm25 is lower-is-better, so it's negated to rank like ts_rank does.
+		// Title hits weigh ten times as much as description hits.
+		query = `
+		SELECT` + qualifiedVideoColumns("v") + `,
+			highlight(videos_fts, 1, '` + highlightStart + `', '` + highlightEnd + `'),
+			snippet(videos_fts, 2, '` + highlightStart + `', '` + highlightEnd + `', '…', 16),
+			-bm25(videos_fts, 0, 10.0, 1.0) AS rank
+		FROM videos_fts
+		JOIN videos v ON v.id = videos_fts.video_id
+		WHERE videos_fts MATCH ? AND ` + accessible + `
+		ORDER BY rank DESC, v.created_at DESC
+		LIMIT ?
+		`
+	}
+
+	args := append([]any{match}, accessibleArgs...)
+	rows, err := c.query(query, append(args, params.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		var title, description string
+		err := scanVideoInto(rows, &result.Video, &title, &description, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		result.TitleHighlight = renderHighlight(title)
+		result.DescriptionSnippet = renderHighlight(description)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// searchVideosLike searches with LIKE, for SQLite built without FTS5. Every
+// term has to appear somewhere in the title or description, and each one
+// found in the title counts ten times as much as one in the description.
+// Matches are highlighted here rather than by the database.
+func (c Client) searchVideosLike(params SearchVideosParams, terms []string) ([]VideoSearchResult, error) {
+	accessible, accessibleArgs := accessibleVideosCondition("v", params.UserID, params.IncludeShared)
+
+	conditions := make([]string, 0, len(terms))
+	ranks := make([]string, 0, len(terms))
+	var conditionArgs, rankArgs []any
+	for _, term := range terms {
+		pattern := "%" + term + "%"
+		conditions = append(conditions, "(v.title LIKE ? OR COALESCE(v.description, '') LIKE ?)")
+		conditionArgs = append(conditionArgs, pattern, pattern)
+		ranks = append(ranks, "(CASE WHEN v.title LIKE ? THEN 10 ELSE 0 END + CASE WHEN COALESCE(v.description, '') LIKE ? THEN 1 ELSE 0 END)")
+		rankArgs = append(rankArgs, pattern, pattern)
+	}
+	query := `
+	SELECT` + qualifiedVideoColumns("v") + `,
+		v.title,
+		COALESCE(v.description, ''),
+		` + strings.Join(ranks, " + ") + ` AS rank
+	FROM videos v
+	WHERE ` + strings.Join(conditions, " AND ") + ` AND ` + accessible + `
+	ORDER BY rank DESC, v.created_at DESC
+	LIMIT ?
+	`
+
+	args := append(rankArgs, conditionArgs...)
+	args = append(args, accessibleArgs...)
+	rows, err := c.query(query, append(args, params.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	match := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		var title, description string
+		err := scanVideoInto(rows, &result.Video, &title, &description, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		result.TitleHighlight = renderHighlight(markMatches(title, match))
+		result.DescriptionSnippet = renderHighlight(markMatches(likeSnippet(description, match), match))
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// snippetWords is how many words of a description a snippet shows, as FTS5
+// snippets do.
+const snippetWords = 16
+
+// likeSnippet cuts text down to snippetWords words, starting a little
+// before the first match.
+func likeSnippet(text string, match *regexp.Regexp) string {
+	words := strings.Fields(text)
+	if len(words) <= snippetWords {
+		return text
+	}
+	first := 0
+	for i, word := range words {
+		if match.MatchString(word) {
+			first = i
+			break
+		}
+	}
+	start := max(0, min(first-snippetWords/4, len(words)-snippetWords))
+	end := start + snippetWords
+
+	snippet := strings.Join(words[start:end], " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(words) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+func markMatches(text string, match *regexp.Regexp) string {
+	return match.ReplaceAllString(text, highlightStart+"$0"+highlightEnd)
+}
+
+// searchTerms splits a user's query into words, dropping punctuation and
+// anything else that the FTS5 or tsquery syntax would interpret.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func renderHighlight(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, highlightStart, "<mark>")
+	return strings.ReplaceAll(s, highlightEnd, "</mark>")
+}
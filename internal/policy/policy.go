@@ -0,0 +1,116 @@
+// Package policy decides what an authenticated caller may do with the
+// resources they ask for. Handlers call it instead of comparing owner IDs
+// themselves, so every rule lives in one place.
+package policy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// ErrDenied is wrapped by every error the checks in this package return.
+var ErrDenied = errors.New("permission denied")
+
+func deny(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrDenied, fmt.Sprintf(format, args...))
+}
+
+func isAdmin(p auth.Principal) bool {
+	return p.Role == auth.RoleAdmin
+}
+
+func owns(p auth.Principal, video database.Video) bool {
+	return video.UserID == p.UserID
+}
+
+// CanCreateVideo allows creators and admins to add videos. Viewers can only
+// watch.
+func CanCreateVideo(p auth.Principal) error {
+	if p.Role != auth.RoleCreator && !isAdmin(p) {
+		return deny("%s users can't create videos", p.Role)
+	}
+	return nil
+}
+
+// VideoCheck decides whether p may do something with video. share is p's
+// grant on the video, or a zero VideoShare if they have none.
+type VideoCheck func(p auth.Principal, video database.Video, share database.VideoShare) error
+
+// grant returns the permission share gives p on video, if it's p's.
+func grant(p auth.Principal, video database.Video, share database.VideoShare) database.SharePermission {
+	if share.UserID != p.UserID || share.VideoID != video.ID {
+		return ""
+	}
+	return share.Permission
+}
+
+// CanViewVideo allows the owner, admins and anyone the video is shared with
+// to see it, and everyone to see videos that aren't private.
+func CanViewVideo(p auth.Principal, video database.Video, share database.VideoShare) error {
+	if video.Visibility != database.VisibilityPrivate {
+		return nil
+	}
+	if !owns(p, video) && !isAdmin(p) && grant(p, video, share) == "" {
+		return deny("video %s belongs to another user", video.ID)
+	}
+	return nil
+}
+
+// CanEditVideo allows admins, and owners or holders of an edit grant who are
+// still creators, to change a video's metadata or media.
+func CanEditVideo(p auth.Principal, video database.Video, share database.VideoShare) error {
+	if isAdmin(p) {
+		return nil
+	}
+	if !owns(p, video) && grant(p, video, share) != database.SharePermissionEdit {
+		return deny("video %s belongs to another user", video.ID)
+	}
+	if p.Role != auth.RoleCreator {
+		return deny("%s users can't edit videos", p.Role)
+	}
+	return nil
+}
+
+// CanDeleteVideo allows the owner and admins to delete a video. Owners keep
+// this when demoted to viewer so they can still take their content down.
+// Grants never allow it.
+func CanDeleteVideo(p auth.Principal, video database.Video, share database.VideoShare) error {
+	if !owns(p, video) && !isAdmin(p) {
+		return deny("video %s belongs to another user", video.ID)
+	}
+	return nil
+}
+
+// CanShareVideo allows the owner and admins to grant and revoke access to a
+// video, create share links for it and change its visibility.
+func CanShareVideo(p auth.Principal, video database.Video, share database.VideoShare) error {
+	if !owns(p, video) && !isAdmin(p) {
+		return deny("only the owner can share video %s", video.ID)
+	}
+	return nil
+}
+
+// CanViewVideoAnonymously allows callers without an account to see public
+// and unlisted videos, and private ones through a share link. link is the
+// one they presented, or a zero ShareLink if they have none.
+func CanViewVideoAnonymously(video database.Video, link database.ShareLink) error {
+	if video.Visibility == database.VisibilityPublic || video.Visibility == database.VisibilityUnlisted {
+		return nil
+	}
+	if link.VideoID != video.ID || !link.Usable() {
+		return deny("video %s is private", video.ID)
+	}
+	return nil
+}
+
+// CanManageUsers allows admins to list users, change their roles and delete
+// them along with their content.
+func CanManageUsers(p auth.Principal) error {
+	if !isAdmin(p) {
+		return deny("only admins can manage users")
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestVideoChecks(t *testing.T) {
+	owner := auth.Principal{UserID: uuid.New(), Role: auth.RoleCreator}
+	demoted := auth.Principal{UserID: owner.UserID, Role: auth.RoleViewer}
+	admin := auth.Principal{UserID: uuid.New(), Role: auth.RoleAdmin}
+	creator := auth.Principal{UserID: uuid.New(), Role: auth.RoleCreator}
+	viewer := auth.Principal{UserID: uuid.New(), Role: auth.RoleViewer}
+
+	private := database.Video{ID: uuid.New(), Visibility: database.VisibilityPrivate}
+	private.UserID = owner.UserID
+	public := database.Video{ID: uuid.New(), Visibility: database.VisibilityPublic}
+	public.UserID = owner.UserID
+	another := database.Video{ID: uuid.New(), Visibility: database.VisibilityPrivate}
+	another.UserID = owner.UserID
+
+	shareWith := func(p auth.Principal, video database.Video, permission database.SharePermission) database.VideoShare {
+		return database.VideoShare{ShareVideoParams: database.ShareVideoParams{
+			VideoID:    video.ID,
+			UserID:     p.UserID,
+			Permission: permission,
+		}}
+	}
+	none := database.VideoShare{}
+
+	tests := []struct {
+		name  string
+		check VideoCheck
+		p     auth.Principal
+		video database.Video
+		share database.VideoShare
+		allow bool
+	}{
+		{"owner views private", CanViewVideo, owner, private, none, true},
+		{"admin views private", CanViewVideo, admin, private, none, true},
+		{"stranger views private", CanViewVideo, creator, private, none, false},
+		{"viewer views private", CanViewVideo, viewer, private, none, false},
+		{"stranger views public", CanViewVideo, viewer, public, none, true},
+		{"grantee views private", CanViewVideo, viewer, private, shareWith(viewer, private, database.SharePermissionView), true},
+		{"grant for another video", CanViewVideo, viewer, private, shareWith(viewer, another, database.SharePermissionView), false},
+		{"grant for another user", CanViewVideo, viewer, private, shareWith(creator, private, database.SharePermissionView), false},
+
+		{"owner edits", CanEditVideo, owner, private, none, true},
+		{"demoted owner edits", CanEditVideo, demoted, private, none, false},
+		{"admin edits", CanEditVideo, admin, private, none, true},
+		{"stranger edits", CanEditVideo, creator, private, none, false},
+		{"stranger edits public", CanEditVideo, creator, public, none, false},
+		{"view grantee edits", CanEditVideo, creator, private, shareWith(creator, private, database.SharePermissionView), false},
+		{"edit grantee edits", CanEditVideo, creator, private, shareWith(creator, private, database.SharePermissionEdit), true},
+		{"viewer with edit grant edits", CanEditVideo, viewer, private, shareWith(viewer, private, database.SharePermissionEdit), false},
+
+		{"owner deletes", CanDeleteVideo, owner, private, none, true},
+		{"demoted owner deletes", CanDeleteVideo, demoted, private, none, true},
+		{"admin deletes", CanDeleteVideo, admin, private, none, true},
+		{"stranger deletes", CanDeleteVideo, creator, private, none, false},
+		{"edit grantee deletes", CanDeleteVideo, creator, private, shareWith(creator, private, database.SharePermissionEdit), false},
+
+		{"owner shares", CanShareVideo, owner, private, none, true},
+		{"admin shares", CanShareVideo, admin, private, none, true},
+		{"stranger shares", CanShareVideo, creator, private, none, false},
+		{"edit grantee shares", CanShareVideo, creator, private, shareWith(creator, private, database.SharePermissionEdit), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check(tt.p, tt.video, tt.share)
+			if tt.allow && err != nil {
+				t.Fatalf("expected access, got %v", err)
+			}
+			if !tt.allow && !errors.Is(err, ErrDenied) {
+				t.Fatalf("expected ErrDenied, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCanViewVideoAnonymously(t *testing.T) {
+	private := database.Video{ID: uuid.New(), Visibility: database.VisibilityPrivate}
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	link := func(videoID uuid.UUID, expiresAt, revokedAt *time.Time) database.ShareLink {
+		return database.ShareLink{
+			RevokedAt:             revokedAt,
+			CreateShareLinkParams: database.CreateShareLinkParams{VideoID: videoID, ExpiresAt: expiresAt},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		video database.Video
+		link  database.ShareLink
+		allow bool
+	}{
+		{"public", database.Video{ID: uuid.New(), Visibility: database.VisibilityPublic}, database.ShareLink{}, true},
+		{"unlisted", database.Video{ID: uuid.New(), Visibility: database.VisibilityUnlisted}, database.ShareLink{}, true},
+		{"private without link", private, database.ShareLink{}, false},
+		{"private with link", private, link(private.ID, nil, nil), true},
+		{"private with unexpired link", private, link(private.ID, &future, nil), true},
+		{"private with expired link", private, link(private.ID, &past, nil), false},
+		{"private with revoked link", private, link(private.ID, nil, &past), false},
+		{"private with another video's link", private, link(uuid.New(), nil, nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CanViewVideoAnonymously(tt.video, tt.link)
+			if tt.allow && err != nil {
+				t.Fatalf("expected access, got %v", err)
+			}
+			if !tt.allow && !errors.Is(err, ErrDenied) {
+				t.Fatalf("expected ErrDenied, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRoleChecks(t *testing.T) {
+	tests := []struct {
+		role         auth.Role
+		createVideos bool
+		manageUsers  bool
+	}{
+		{auth.RoleAdmin, true, true},
+		{auth.RoleCreator, true, false},
+		{auth.RoleViewer, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.role), func(t *testing.T) {
+			p := auth.Principal{UserID: uuid.New(), Role: tt.role}
+			if err := CanCreateVideo(p); (err == nil) != tt.createVideos {
+				t.Errorf("CanCreateVideo: got %v, want allowed=%v", err, tt.createVideos)
+			}
+			if err := CanManageUsers(p); (err == nil) != tt.manageUsers {
+				t.Errorf("CanManageUsers: got %v, want allowed=%v", err, tt.manageUsers)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often stores forget buckets that have filled up,
+// which are no different from new ones.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps buckets in memory, so each instance of the app limits
+// clients on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]memoryBucket{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !b.fullAt.After(now) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b := s.buckets[key]
+	tokens, result := limit.take(b.tokens, b.updatedAt, now)
+	s.buckets[key] = memoryBucket{
+		tokens:    tokens,
+		updatedAt: now,
+		fullAt:    now.Add(result.Reset),
+	}
+	return result, nil
+}
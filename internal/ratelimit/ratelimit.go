@@ -0,0 +1,122 @@
+// Package ratelimit limits how often clients can make requests, with a token
+// bucket per client: a bucket holds up to Burst tokens and refills at Burst
+// tokens per Period, and each request takes one.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is the size and refill rate of a bucket. The zero Limit doesn't
+// limit anything.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// ParseLimit parses a limit written as "<burst>/<period>", such as "10/1m"
+// for ten requests a minute. "0" is no limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "0" {
+		return Limit{}, nil
+	}
+	burst, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected <requests>/<period>", s)
+	}
+	n, err := strconv.Atoi(burst)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("invalid limit %q: requests must be a positive number", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q: period must be a positive duration", s)
+	}
+	return Limit{Burst: n, Period: d}, nil
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Burst <= 0
+}
+
+// rate is how many tokens are added to a bucket per second.
+func (l Limit) rate() float64 {
+	return float64(l.Burst) / l.Period.Seconds()
+}
+
+// Result is the state of a client's bucket after a request.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a request would be allowed, when this
+	// one wasn't.
+	RetryAfter time.Duration
+}
+
+// take refills a bucket that held tokens at updatedAt, and takes a token
+// from it when there is one. A zero updatedAt is a new bucket, which is full.
+func (l Limit) take(tokens float64, updatedAt, now time.Time) (float64, Result) {
+	burst := float64(l.Burst)
+	if updatedAt.IsZero() {
+		tokens = burst
+	} else if elapsed := now.Sub(updatedAt); elapsed > 0 {
+		tokens = min(burst, tokens+elapsed.Seconds()*l.rate())
+	}
+
+	result := Result{Limit: l}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / l.rate())
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((burst - tokens) / l.rate())
+	return tokens, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store keeps the buckets of every client.
+type Store interface {
+	// Take takes a token from the bucket for key, which has the given limit.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// SetHeaders describes result in the RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers of the IETF RateLimit header
+// fields draft, and in Retry-After when the request wasn't allowed.
+func (result Result) SetHeaders(h http.Header) {
+	h.Set("RateLimit-Limit", strconv.Itoa(result.Limit.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit.Burst, ceilSeconds(result.Limit.Period)))
+	if !result.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(result.RetryAfter))))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ClientIP is the address a request came from, without its port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{in: "10/1m", want: Limit{Burst: 10, Period: time.Minute}},
+		{in: "30/10m", want: Limit{Burst: 30, Period: 10 * time.Minute}},
+		{in: "0", want: Limit{}},
+		{in: "10", wantErr: true},
+		{in: "0/1m", wantErr: true},
+		{in: "-1/1m", wantErr: true},
+		{in: "10/0s", wantErr: true},
+		{in: "10/soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLimit(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if limit, _ := ParseLimit("0"); !limit.Unlimited() {
+		t.Error(`"0" should be unlimited`)
+	}
+}
+
+func TestTakeRefills(t *testing.T) {
+	limit := Limit{Burst: 2, Period: 2 * time.Second}
+	now := time.Now()
+
+	tokens, result := limit.take(0, time.Time{}, now)
+	if !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("first request: got %+v", result)
+	}
+	tokens, result = limit.take(tokens, now, now)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("second request: got %+v", result)
+	}
+	tokens, result = limit.take(tokens, now, now)
+	if result.Allowed {
+		t.Fatalf("third request should be refused: got %+v", result)
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("RetryAfter: got %v, want 1s", result.RetryAfter)
+	}
+	if result.Reset != 2*time.Second {
+		t.Errorf("Reset: got %v, want 2s", result.Reset)
+	}
+
+	// One token a second comes back
+	_, result = limit.take(tokens, now, now.Add(time.Second))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("request after a second: got %+v", result)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Burst: 3, Period: time.Hour}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		result, err := store.Take(ctx, "a", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("request %d: got %+v", i+1, result)
+		}
+	}
+	result, _ := store.Take(ctx, "a", limit)
+	if result.Allowed {
+		t.Fatalf("fourth request should be refused: got %+v", result)
+	}
+	result, _ = store.Take(ctx, "b", limit)
+	if !result.Allowed {
+		t.Fatalf("another key has its own bucket: got %+v", result)
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	h := http.Header{}
+	Result{
+		Allowed:    false,
+		Limit:      Limit{Burst: 10, Period: time.Minute},
+		Remaining:  0,
+		Reset:      1500 * time.Millisecond,
+		RetryAfter: 200 * time.Millisecond,
+	}.SetHeaders(h)
+
+	want := map[string]string{
+		"RateLimit-Limit":     "10",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "2",
+		"RateLimit-Policy":    "10;w=60",
+		"Retry-After":         "1",
+	}
+	for name, value := range want {
+		if got := h.Get(name); got != value {
+			t.Errorf("%s: got %q, want %q", name, got, value)
+		}
+	}
+
+	h = http.Header{}
+	Result{Allowed: true, Limit: Limit{Burst: 10, Period: time.Minute}, Remaining: 9}.SetHeaders(h)
+	if h.Get("Retry-After") != "" {
+		t.Errorf("Retry-After is only set on refused requests, got %q", h.Get("Retry-After"))
+	}
+}
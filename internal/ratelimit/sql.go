@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BucketDB keeps buckets in a database that every instance of the app
+// shares.
+type BucketDB interface {
+	// UpdateRateLimitBucket replaces the bucket for key with what update
+	// returns for it, atomically. A bucket that doesn't exist yet is passed
+	// to update with a zero updatedAt, and is stored as updated at now.
+	UpdateRateLimitBucket(key string, now time.Time, update func(tokens float64, updatedAt time.Time) (newTokens float64, fullAt time.Time)) error
+	// DeleteFullRateLimitBuckets forgets the buckets that are full by now.
+	DeleteFullRateLimitBuckets(now time.Time) error
+}
+
+// SQLStore keeps buckets in a database, so clients are limited across every
+// instance of the app.
+type SQLStore struct {
+	db        BucketDB
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewSQLStore(db BucketDB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+	if s.sweepDue(now) {
+		err := s.db.DeleteFullRateLimitBuckets(now)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	var result Result
+	err := s.db.UpdateRateLimitBucket(key, now, func(tokens float64, updatedAt time.Time) (float64, time.Time) {
+		tokens, result = limit.take(tokens, updatedAt, now)
+		return tokens, now.Add(result.Reset)
+	})
+	return result, err
+}
+
+// sweepDue reports whether this instance should forget full buckets now,
+// which it does at most every sweepInterval.
+func (s *SQLStore) sweepDue(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return false
+	}
+	s.lastSweep = now
+	return true
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocalStore(t *testing.T) (*LocalStore, string) {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "assets")
+	s, err := NewLocalStore(root, "http://localhost:8091/assets/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, root
+}
+
+func TestLocalStorePutGet(t *testing.T) {
+	s, _ := newTestLocalStore(t)
+	ctx := context.Background()
+
+	// The content type comes from the extension. Only Go's built-in types
+	// are checked, the rest depend on the system's MIME database.
+	tests := []struct {
+		key         string
+		body        string
+		contentType string
+	}{
+		{"thumbnails/a.png", "png bytes", "image/png"},
+		{"landscape/b.json", "{}", "application/json"},
+		{"landscape/b/hls/index.m3u8", "#EXTM3U", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := s.Put(ctx, tt.key, strings.NewReader(tt.body), tt.contentType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, info, err := s.Get(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(body)
+			body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("body: got %q, want %q", got, tt.body)
+			}
+			if info.Key != tt.key || info.Size != int64(len(tt.body)) {
+				t.Errorf("unexpected info %+v", info)
+			}
+			if tt.contentType != "" && info.ContentType != tt.contentType {
+				t.Errorf("content type: got %q, want %q", info.ContentType, tt.contentType)
+			}
+
+			head, err := s.Head(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head != info {
+				t.Errorf("Head and Get disagree: %+v, %+v", head, info)
+			}
+		})
+	}
+
+	// Putting a key again replaces the object
+	err := s.Put(ctx, "thumbnails/a.png", strings.NewReader("new"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Head(ctx, "thumbnails/a.png")
+	if err != nil || info.Size != 3 {
+		t.Fatalf("replaced object: got %+v, %v", info, err)
+	}
+}
+
+func TestLocalStoreNotFound(t *testing.T) {
+	s, _ := newTestLocalStore(t)
+	ctx := context.Background()
+	err := s.Put(ctx, "landscape/b.mp4", strings.NewReader("mp4"), "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"missing.mp4", "landscape/missing.mp4", "landscape"} {
+		if _, err := s.Head(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Head(%q): got %v, want ErrNotFound", key, err)
+		}
+	}
+	if _, _, err := s.Get(ctx, "missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get: got %v, want ErrNotFound", err)
+	}
+
+	if err := s.Delete(ctx, "landscape/b.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Head(ctx, "landscape/b.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Head after Delete: got %v, want ErrNotFound", err)
+	}
+	// Deleting what's already gone isn't an error, so deletions can be retried
+	if err := s.Delete(ctx, "landscape/b.mp4"); err != nil {
+		t.Errorf("Delete of a missing object: got %v", err)
+	}
+}
+
+func TestLocalStoreRejectsInvalidKeys(t *testing.T) {
+	s, root := newTestLocalStore(t)
+	ctx := context.Background()
+	secret := filepath.Join(filepath.Dir(root), "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"", ".", "/", "../secret.txt", "thumbnails/../../secret.txt", "/etc/passwd", "thumbnails//a.png", "thumbnails/./a.png", "thumbnails/"}
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			if err := s.Put(ctx, key, strings.NewReader("x"), "text/plain"); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Put: got %v, want ErrInvalidKey", err)
+			}
+			if _, _, err := s.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Get: got %v, want ErrInvalidKey", err)
+			}
+			if _, err := s.Head(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Head: got %v, want ErrInvalidKey", err)
+			}
+			if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Delete: got %v, want ErrInvalidKey", err)
+			}
+		})
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Fatalf("file outside the root was touched: %v", err)
+	}
+}
+
+func TestLocalStoreList(t *testing.T) {
+	s, root := newTestLocalStore(t)
+	ctx := context.Background()
+	for _, key := range []string{"landscape/a.mp4", "landscape/a/hls/index.m3u8", "portrait/b.mp4", "thumbnails/c.png"} {
+		if err := s.Put(ctx, key, strings.NewReader(key), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A write in progress isn't an object yet
+	if err := os.WriteFile(filepath.Join(root, "landscape", ".upload-123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"landscape/a.mp4", "landscape/a/hls/index.m3u8", "portrait/b.mp4", "thumbnails/c.png"}},
+		{"landscape/", []string{"landscape/a.mp4", "landscape/a/hls/index.m3u8"}},
+		{"landscape/a/", []string{"landscape/a/hls/index.m3u8"}},
+		{"thumb", []string{"thumbnails/c.png"}},
+		{"other/", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			objects, err := s.List(ctx, tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := []string{}
+			for _, obj := range objects {
+				keys = append(keys, obj.Key)
+			}
+			slices.Sort(keys)
+			if !slices.Equal(keys, tt.want) {
+				t.Fatalf("got %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalStorePresign(t *testing.T) {
+	s, _ := newTestLocalStore(t)
+	url, err := s.Presign(context.Background(), "landscape/a.mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://localhost:8091/assets/landscape/a.mp4"; url != want {
+		t.Fatalf("got %q, want %q", url, want)
+	}
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// ErrInvalidKey is returned for keys a store can't hold, such as ones that
+// would reach outside a local store's root.
+var ErrInvalidKey = errors.New("invalid object key")
+
+// ObjectInfo describes a stored object without its contents.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+// BlobStore is the storage backend used for every media object (videos and
+// thumbnails). Keys are slash separated and never start with a slash.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Presign returns a URL that can be used to GET the object until expiresIn
+	// has elapsed. Backends that cannot sign return a plain public URL.
+	Presign(ctx context.Context, key string, expiresIn time.Duration) (string, error)
+}
+
+// DirectUploader is implemented by backends that clients can upload to
+// directly, without streaming the bytes through the app. Presigned URLs are
+// only good for a body of exactly size bytes.
+type DirectUploader interface {
+	PresignPut(ctx context.Context, key, contentType string, size int64, expiresIn time.Duration) (string, error)
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, size int64, expiresIn time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
@@ -1,158 +1,372 @@
 package main
 
 import (
-    "context"
-    "fmt"
-    "log"
-    "net/http"
-    "os"
-    "path/filepath" 
-    "strings"
-
-    "github.com/aws/aws-sdk-go-v2/config"       
-    "github.com/aws/aws-sdk-go-v2/service/s3"   
-    "github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-    "github.com/dgrijalva/jwt-go"
-    "github.com/go-chi/chi/v5"
-    "github.com/joho/godotenv"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cloudfront"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/policy"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/joho/godotenv"
 )
 
 type apiConfig struct {
-    db         *database.Client
-    jwtSecret  string
-    port       string
-    assetsRoot string
-    platform   string
-    s3Bucket   string
-    s3Region   string
-    s3Client   *s3.Client
-    cloudFrontDomain string
+	db                *database.Client
+	signingKeys       *auth.KeySet
+	signingKeyOpts    signingKeyOptions
+	port              string
+	assetsRoot        string
+	platform          string
+	s3Bucket          string
+	s3Region          string
+	storageBackend    string
+	store             storage.BlobStore
+	cloudFrontDomain  string
+	cdnSigner         *cloudfront.Signer
+	mediaOpts         mediaURLOptions
+	mediaCacheControl string
+	uploadsDir        string
+	uploadExpiry      time.Duration
+	jobWake           chan struct{}
+	thumbnailOpts     thumbnailOptions
+	gcOpts            gcOptions
+	quotaOpts         quotaOptions
+	rateLimiter       ratelimit.Store
 }
 
 func main() {
-    err := godotenv.Load()
-    if err != nil {
-        log.Fatal("Error loading .env file")
-    }
-
-    dbPath := os.Getenv("DB_PATH")
-    jwtSecret := os.Getenv("JWT_SECRET")
-    port := os.Getenv("PORT")
-    assetsRoot := os.Getenv("ASSETS_ROOT")
-    platform := os.Getenv("PLATFORM")
-    s3Bucket := os.Getenv("S3_BUCKET")
-    s3Region := os.Getenv("S3_REGION")
-    cloudFrontDomain := os.Getenv("CLOUDFRONT_DOMAIN")
-      if cloudFrontDomain == "" {
-    log.Fatal("CLOUDFRONT_DOMAIN not set in .env")
-    }
-
-    // Load AWS SDK configuration
-    cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
-    if err != nil {
-        log.Fatal("Error loading AWS config:", err)
-    }
-
-    // Create S3 client
-    s3Client := s3.NewFromConfig(cfg)
-
-    client, err := database.NewClient(dbPath)
-    if err != nil {
-        log.Fatal("Error connecting to database:", err)
-    }
-    apiCfg := apiConfig{
-        db:         &client,
-        jwtSecret:  jwtSecret,
-        port:       port,
-        assetsRoot: assetsRoot,
-        platform:   platform,
-        s3Bucket:   s3Bucket,
-        s3Region:   s3Region,
-        s3Client:   s3Client,
-        cloudFrontDomain: cloudFrontDomain,
-    }
-
-    r := chi.NewRouter()
-
-    // Public routes (no auth middleware)
-    r.Post("/api/login", apiCfg.handlerLogin)
-    r.Get("/app/*", apiCfg.assetsHandler)
-    r.With(noCacheMiddleware).Get("/assets/*", apiCfg.assetsHandler)
-
-    // Protected routes (with auth middleware)
-    r.Group(func(r chi.Router) {
-        r.Use(apiCfg.authMiddleware)
-        r.Get("/api/videos", apiCfg.handlerVideosRetrieve)
-        r.Get("/api/videos/{videoID}", apiCfg.handlerVideoGet)
-        r.Post("/api/videos", apiCfg.handlerVideoMetaCreate)
-        r.Post("/api/thumbnail_upload/{videoID}", apiCfg.handlerUploadThumbnail)
-        r.Post("/api/video_upload/{videoID}", apiCfg.handlerUploadVideo)
-        r.Delete("/api/videos/{videoID}", apiCfg.handlerVideoMetaDelete) 
-    })
-
-    fmt.Printf("Server starting on port %s...\n", port)
-    err = http.ListenAndServe(":"+port, r)
-    if err != nil {
-        log.Fatal("Server failed to start:", err)
-    }
-}
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
 
-func (cfg *apiConfig) authMiddleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        authHeader := r.Header.Get("Authorization")
-        if authHeader == "" {
-            respondWithError(w, http.StatusUnauthorized, "Missing Authorization header", nil)
-            return
-        }
-
-        tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-        if tokenStr == authHeader {
-            respondWithError(w, http.StatusUnauthorized, "Invalid Authorization header format", nil)
-            return
-        }
-
-        token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-            if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-                return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-            }
-            return []byte(cfg.jwtSecret), nil
-        })
-        if err != nil {
-            respondWithError(w, http.StatusUnauthorized, "Invalid token: "+err.Error(), nil)
-            return
-        }
-
-        if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-            userID, ok := claims["sub"].(string)
-            if !ok {
-                respondWithError(w, http.StatusUnauthorized, "Invalid token claims: missing sub", nil)
-                return
-            }
-            ctx := context.WithValue(r.Context(), "userID", userID)
-            next.ServeHTTP(w, r.WithContext(ctx))
-        } else {
-            respondWithError(w, http.StatusUnauthorized, "Invalid token claims: claims invalid or token not valid", nil)
-            return
-        }
-    })
-}
+	// DATABASE_URL (a postgres:// URL) takes precedence over the SQLite DB_PATH
+	dbPath := os.Getenv("DATABASE_URL")
+	if dbPath == "" {
+		dbPath = os.Getenv("DB_PATH")
+	}
+	if len(os.Args) > 1 {
+		err := runCommand(dbPath, os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	port := os.Getenv("PORT")
+	assetsRoot := os.Getenv("ASSETS_ROOT")
+	platform := os.Getenv("PLATFORM")
+	s3Bucket := os.Getenv("S3_BUCKET")
+	s3Region := os.Getenv("S3_REGION")
+	cloudFrontDomain := os.Getenv("CLOUDFRONT_DOMAIN")
+	uploadsDir := os.Getenv("UPLOADS_DIR")
+	if uploadsDir == "" {
+		uploadsDir = "./uploads"
+	}
+	videoWorkers := 2
+	if v := os.Getenv("VIDEO_WORKERS"); v != "" {
+		videoWorkers, err = strconv.Atoi(v)
+		if err != nil || videoWorkers < 1 {
+			log.Fatalf("Invalid VIDEO_WORKERS %q", v)
+		}
+	}
+	thumbnailOpts := thumbnailOptions{
+		Mode:      os.Getenv("THUMBNAIL_MODE"),
+		Timestamp: 2 * time.Second,
+	}
+	switch thumbnailOpts.Mode {
+	case "":
+		thumbnailOpts.Mode = "timestamp"
+	case "timestamp", "scene":
+	default:
+		log.Fatalf("Unknown THUMBNAIL_MODE %q, expected \"timestamp\" or \"scene\"", thumbnailOpts.Mode)
+	}
+	if v := os.Getenv("THUMBNAIL_TIMESTAMP"); v != "" {
+		thumbnailOpts.Timestamp, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid THUMBNAIL_TIMESTAMP %q: %v", v, err)
+		}
+	}
+	signingKeyOpts := signingKeyOptions{
+		Algorithm: os.Getenv("JWT_SIGNING_ALG"),
+		Rotation:  30 * 24 * time.Hour,
+	}
+	switch signingKeyOpts.Algorithm {
+	case "":
+		signingKeyOpts.Algorithm = auth.AlgorithmRS256
+	case auth.AlgorithmRS256, auth.AlgorithmEdDSA:
+	default:
+		log.Fatalf("Unknown JWT_SIGNING_ALG %q, expected %q or %q", signingKeyOpts.Algorithm, auth.AlgorithmRS256, auth.AlgorithmEdDSA)
+	}
+	if v := os.Getenv("JWT_KEY_ROTATION"); v != "" {
+		signingKeyOpts.Rotation, err = time.ParseDuration(v)
+		if err != nil || signingKeyOpts.Rotation <= signingKeyLead {
+			log.Fatalf("Invalid JWT_KEY_ROTATION %q, expected a duration longer than %s", v, signingKeyLead)
+		}
+	}
+	mediaOpts := mediaURLOptions{
+		Mode:         os.Getenv("MEDIA_URL_MODE"),
+		TTL:          time.Hour,
+		CookieDomain: os.Getenv("CLOUDFRONT_COOKIE_DOMAIN"),
+	}
+	switch mediaOpts.Mode {
+	case "":
+		mediaOpts.Mode = mediaURLCDN
+	case mediaURLCDN, mediaURLPublic, mediaURLPresigned:
+	default:
+		log.Fatalf("Unknown MEDIA_URL_MODE %q, expected %q, %q or %q", mediaOpts.Mode, mediaURLCDN, mediaURLPublic, mediaURLPresigned)
+	}
+	if v := os.Getenv("MEDIA_URL_TTL"); v != "" {
+		mediaOpts.TTL, err = time.ParseDuration(v)
+		if err != nil || mediaOpts.TTL <= 0 {
+			log.Fatalf("Invalid MEDIA_URL_TTL %q", v)
+		}
+	}
+	if v := os.Getenv("CLOUDFRONT_RESTRICT_IP"); v != "" {
+		mediaOpts.RestrictIP, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid CLOUDFRONT_RESTRICT_IP %q", v)
+		}
+	}
+	// Unfinished resumable uploads are deleted once they haven't been
+	// written to for this long
+	uploadExpiry := 24 * time.Hour
+	if v := os.Getenv("TUS_UPLOAD_EXPIRY"); v != "" {
+		uploadExpiry, err = time.ParseDuration(v)
+		if err != nil || uploadExpiry <= 0 {
+			log.Fatalf("Invalid TUS_UPLOAD_EXPIRY %q", v)
+		}
+	}
+	gcOpts, err := gcOptionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	quotaOpts, err := quotaOptionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rateLimits, err := rateLimitOptionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Behind a proxy every request comes from the proxy, which passes the
+	// client's address on in X-Forwarded-For or X-Real-IP
+	trustProxyHeaders := false
+	if v := os.Getenv("TRUST_PROXY_HEADERS"); v != "" {
+		trustProxyHeaders, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid TRUST_PROXY_HEADERS %q", v)
+		}
+	}
+	mediaCacheControl := os.Getenv("MEDIA_CACHE_CONTROL")
+	if mediaCacheControl == "" {
+		mediaCacheControl = defaultMediaCacheControl
+	}
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
+	}
+
+	if storageBackend == "s3" && cloudFrontDomain == "" && mediaOpts.Mode == mediaURLCDN {
+		log.Fatal("CLOUDFRONT_DOMAIN not set in .env")
+	}
+	store, err := newBlobStore(context.Background(), storageBackend, s3Bucket, s3Region, assetsRoot, port)
+	if err != nil {
+		log.Fatal("Error opening storage:", err)
+	}
+
+	// Without a key pair the distribution is expected to be public
+	var cdnSigner *cloudfront.Signer
+	if keyPairID := os.Getenv("CLOUDFRONT_KEY_PAIR_ID"); keyPairID != "" && storageBackend == "s3" {
+		keyPEM, err := os.ReadFile(os.Getenv("CLOUDFRONT_PRIVATE_KEY_PATH"))
+		if err != nil {
+			log.Fatal("Error reading CloudFront private key:", err)
+		}
+		cdnSigner, err = cloudfront.NewSigner(keyPairID, keyPEM)
+		if err != nil {
+			log.Fatal("Error loading CloudFront key pair:", err)
+		}
+	}
+
+	client, err := database.NewClient(dbPath)
+	if err != nil {
+		log.Fatal("Error connecting to database:", err)
+	}
+	if !client.FullTextSearch() {
+		log.Println("SQLite was built without FTS5, so video search falls back to LIKE: build with make (-tags sqlite_fts5) for ranked full-text search")
+	}
+	var rateLimiter ratelimit.Store = ratelimit.NewMemoryStore()
+	if rateLimits.Store == "database" {
+		rateLimiter = ratelimit.NewSQLStore(client)
+	}
+	apiCfg := apiConfig{
+		db:                &client,
+		signingKeys:       auth.NewKeySet(),
+		signingKeyOpts:    signingKeyOpts,
+		port:              port,
+		assetsRoot:        assetsRoot,
+		platform:          platform,
+		s3Bucket:          s3Bucket,
+		s3Region:          s3Region,
+		storageBackend:    storageBackend,
+		store:             store,
+		cloudFrontDomain:  cloudFrontDomain,
+		cdnSigner:         cdnSigner,
+		mediaOpts:         mediaOpts,
+		mediaCacheControl: mediaCacheControl,
+		uploadsDir:        uploadsDir,
+		uploadExpiry:      uploadExpiry,
+		jobWake:           make(chan struct{}, 1),
+		thumbnailOpts:     thumbnailOpts,
+		gcOpts:            gcOpts,
+		quotaOpts:         quotaOpts,
+		rateLimiter:       rateLimiter,
+	}
+
+	err = os.MkdirAll(uploadsDir, 0755)
+	if err != nil {
+		log.Fatal("Couldn't create uploads directory:", err)
+	}
+	err = apiCfg.startSigningKeyRotation(context.Background())
+	if err != nil {
+		log.Fatal("Couldn't load signing keys:", err)
+	}
+	err = apiCfg.assignStorageLocations()
+	if err != nil {
+		log.Fatal("Couldn't assign storage locations:", err)
+	}
+	err = apiCfg.startVideoWorkers(context.Background(), videoWorkers)
+	if err != nil {
+		log.Fatal("Couldn't start video workers:", err)
+	}
+	apiCfg.startGarbageCollector(context.Background())
+	apiCfg.startUploadSweeper(context.Background())
+
+	r := chi.NewRouter()
+	if trustProxyHeaders {
+		r.Use(middleware.RealIP)
+	}
 
+	// Public routes (no auth middleware). Credentials are guessed at from
+	// many addresses at once, so each one gets few tries.
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.rateLimit("auth", rateLimits.Auth, rateLimitByIP, false))
+		r.Post("/api/login", apiCfg.handlerLogin)
+		r.Post("/api/users", apiCfg.handlerUsersCreate)
+		r.Post("/api/refresh", apiCfg.handlerRefresh)
+		r.Post("/api/revoke", apiCfg.handlerRevoke)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.rateLimit("public", rateLimits.Public, rateLimitByIP, rateLimits.FailOpen))
+		r.Get("/.well-known/jwks.json", apiCfg.handlerJWKS)
+		r.Get("/api/public/videos", apiCfg.handlerPublicVideosList)
+		r.Get("/api/public/videos/{videoID}", apiCfg.handlerPublicVideoGet)
+	})
+	r.Options("/api/video_upload/{videoID}/tus", apiCfg.handlerTusOptions)
+	r.Options("/api/tus/{uploadID}", apiCfg.handlerTusOptions)
+	r.Get("/app/*", apiCfg.assetsHandler)
+	r.Get("/assets/*", apiCfg.handlerMediaStream)
+	r.Head("/assets/*", apiCfg.handlerMediaStream)
+
+	authenticator := auth.Authenticator{
+		Keys:         apiCfg.signingKeys,
+		LookupAPIKey: apiCfg.authenticateAPIKey,
+		LookupRole:   apiCfg.lookupUserRole,
+		Unauthorized: func(w http.ResponseWriter, r *http.Request, err error) {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate credentials", err)
+		},
+		Forbidden: func(w http.ResponseWriter, r *http.Request, err error) {
+			respondWithError(w, http.StatusForbidden, "Credentials don't allow this action", err)
+		},
+	}
+	canRead := authenticator.RequireScope(auth.ScopeRead)
+	canUpload := authenticator.RequireScope(auth.ScopeUpload)
+	canDelete := authenticator.RequireScope(auth.ScopeDelete)
+	adminOnly := requirePolicy(policy.CanManageUsers)
+	uploadLimit := apiCfg.rateLimit("upload", rateLimits.Upload, rateLimitByPrincipal, rateLimits.FailOpen)
+
+	// Protected routes (with auth middleware). Session tokens carry every
+	// scope; API keys only the ones they were created with.
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.rateLimit("api-ip", rateLimits.APIByIP, rateLimitByIP, false))
+		r.Use(authenticator.Middleware)
+		r.Use(apiCfg.rateLimit("api", rateLimits.API, rateLimitByPrincipal, rateLimits.FailOpen))
+		r.With(canRead).Get("/api/videos", apiCfg.handlerVideosRetrieve)
+		r.With(canRead).Get("/api/videos/search", apiCfg.handlerVideosSearch)
+		r.With(canRead).Get("/api/videos/{videoID}", apiCfg.handlerVideoGet)
+		r.With(canUpload).Post("/api/videos", apiCfg.handlerVideoMetaCreate)
+		r.With(canUpload).Patch("/api/videos/{videoID}", apiCfg.handlerVideoMetaUpdate)
+		r.With(canUpload, uploadLimit).Post("/api/thumbnail_upload/{videoID}", apiCfg.handlerUploadThumbnail)
+		r.With(canUpload, uploadLimit).Post("/api/video_upload/{videoID}", apiCfg.handlerUploadVideo)
+		r.With(canDelete).Delete("/api/videos/{videoID}", apiCfg.handlerVideoMetaDelete)
+		r.With(canRead).Get("/api/me/usage", apiCfg.handlerUsageGet)
+
+		// Sharing with other users and through anonymous links, managed by
+		// the video's owner
+		r.With(canRead).Get("/api/videos/{videoID}/shares", apiCfg.handlerVideoSharesList)
+		r.With(canUpload).Post("/api/videos/{videoID}/shares", apiCfg.handlerVideoSharesCreate)
+		r.With(canUpload).Delete("/api/videos/{videoID}/shares/{userID}", apiCfg.handlerVideoSharesRevoke)
+		r.With(canRead).Get("/api/videos/{videoID}/share_links", apiCfg.handlerShareLinksList)
+		r.With(canUpload).Post("/api/videos/{videoID}/share_links", apiCfg.handlerShareLinksCreate)
+		r.With(canUpload).Delete("/api/videos/{videoID}/share_links/{linkID}", apiCfg.handlerShareLinksRevoke)
+
+		// Direct-to-storage uploads
+		r.With(canUpload, uploadLimit).Post("/api/video_upload/{videoID}/direct", apiCfg.handlerDirectUploadCreate)
+		r.With(canUpload).Post("/api/video_upload/{videoID}/direct/complete", apiCfg.handlerDirectUploadComplete)
+
+		// Resumable uploads (tus 1.0)
+		r.With(canUpload, uploadLimit).Post("/api/video_upload/{videoID}/tus", apiCfg.handlerTusCreate)
+		r.With(canUpload).Head("/api/tus/{uploadID}", apiCfg.handlerTusHead)
+		r.With(canUpload).Patch("/api/tus/{uploadID}", apiCfg.handlerTusPatch)
+		r.With(canUpload).Delete("/api/tus/{uploadID}", apiCfg.handlerTusDelete)
+
+		// API keys, managed from a logged-in session only
+		r.Post("/api/api_keys", apiCfg.handlerAPIKeysCreate)
+		r.Get("/api/api_keys", apiCfg.handlerAPIKeysList)
+		r.Patch("/api/api_keys/{keyID}", apiCfg.handlerAPIKeysRename)
+		r.Delete("/api/api_keys/{keyID}", apiCfg.handlerAPIKeysRevoke)
+
+		// Administration. Admins can also view and delete anyone's videos
+		// through the routes above.
+		r.With(adminOnly, canRead).Get("/api/admin/users", apiCfg.handlerAdminUsersList)
+		r.With(adminOnly, canRead).Get("/api/admin/users/{userID}/videos", apiCfg.handlerAdminUserVideos)
+		r.With(adminOnly).Put("/api/admin/users/{userID}/role", apiCfg.handlerAdminUserSetRole)
+		r.With(adminOnly, canRead).Get("/api/admin/users/{userID}/usage", apiCfg.handlerAdminUserUsage)
+		r.With(adminOnly).Put("/api/admin/users/{userID}/quota", apiCfg.handlerAdminUserSetQuota)
+		r.With(adminOnly, canDelete).Delete("/api/admin/users/{userID}", apiCfg.handlerAdminUserDelete)
+		r.With(adminOnly, canDelete).Post("/admin/reset", apiCfg.handlerReset)
+	})
+
+	fmt.Printf("Server starting on port %s...\n", port)
+	err = http.ListenAndServe(":"+port, r)
+	if err != nil {
+		log.Fatal("Server failed to start:", err)
+	}
+}
 
 func (cfg *apiConfig) assetsHandler(w http.ResponseWriter, r *http.Request) {
-    path := r.URL.Path
-    log.Printf("Requested path: %s", path)
-    if strings.HasPrefix(path, "/app") {
-        if path == "/app" || path == "/app/" {
-            filePath := filepath.Join(cfg.assetsRoot, "index.html")
-            log.Printf("Serving UI file: %s", filePath)
-            http.ServeFile(w, r, filePath)
-            return
-        }
-        log.Printf("Serving app dir: %s", cfg.assetsRoot)
-        http.StripPrefix("/app/", http.FileServer(http.Dir(cfg.assetsRoot))).ServeHTTP(w, r)
-    } else if strings.HasPrefix(path, "/assets") {
-        assetsDir := "assets/assets"
-        log.Printf("Serving assets dir: %s", assetsDir)
-        http.StripPrefix("/assets/", http.FileServer(http.Dir(assetsDir))).ServeHTTP(w, r)
-    }
+	path := r.URL.Path
+	log.Printf("Requested path: %s", path)
+	if strings.HasPrefix(path, "/app") {
+		if path == "/app" || path == "/app/" {
+			filePath := filepath.Join(cfg.assetsRoot, "index.html")
+			log.Printf("Serving UI file: %s", filePath)
+			http.ServeFile(w, r, filePath)
+			return
+		}
+		log.Printf("Serving app dir: %s", cfg.assetsRoot)
+		http.StripPrefix("/app/", http.FileServer(http.Dir(cfg.assetsRoot))).ServeHTTP(w, r)
+	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// newTestConfig returns a config backed by a fresh SQLite database and a
+// local store, both in a temporary directory. The database is at
+// testDBPath(cfg).
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	uploadsDir := filepath.Join(dir, "uploads")
+	err := os.Mkdir(uploadsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store, err := storage.NewLocalStore(filepath.Join(dir, "assets"), "http://localhost/assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &apiConfig{
+		db:             &db,
+		storageBackend: "local",
+		store:          store,
+		uploadsDir:     uploadsDir,
+		uploadExpiry:   24 * time.Hour,
+		jobWake:        make(chan struct{}, 1),
+		gcOpts:         gcOptions{GracePeriod: time.Hour},
+		rateLimiter:    ratelimit.NewMemoryStore(),
+	}
+}
+
+func testDBPath(cfg *apiConfig) string {
+	return filepath.Join(filepath.Dir(cfg.uploadsDir), "tubely.db")
+}
+
+// createTestPrincipal creates a user with the given role and returns them as
+// a signed-in principal.
+func createTestPrincipal(t *testing.T, cfg *apiConfig, email string, role auth.Role) auth.Principal {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.SetUserRole(user.ID, string(role))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth.Principal{UserID: user.ID, Role: role, Scopes: auth.AllScopes}
+}
+
+// signedInAs stands in for the authentication middleware.
+func signedInAs(p auth.Principal) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
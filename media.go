@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// How URLs for media in S3 are built.
+const (
+	// mediaURLCDN serves media through CLOUDFRONT_DOMAIN, signed when a
+	// CloudFront key pair is configured.
+	mediaURLCDN = "cdn"
+	// mediaURLPublic links to the bucket directly, for public buckets.
+	mediaURLPublic = "public"
+	// mediaURLPresigned hands out S3 presigned GET URLs.
+	mediaURLPresigned = "presigned"
+)
+
+// mediaURLOptions control the URLs, signed URLs and cookies handed out for
+// stored media.
+type mediaURLOptions struct {
+	// Mode is one of the mediaURL* constants.
+	Mode string
+	// TTL is how long a signed URL or cookie stays valid.
+	TTL time.Duration
+	// RestrictIP limits CloudFront signatures to the address that asked for
+	// them.
+	RestrictIP bool
+	// CookieDomain is the domain HLS cookies are set for. It must cover
+	// both the app and CLOUDFRONT_DOMAIN; without it no cookies are set.
+	CookieDomain string
+}
+
+// storageLocation is where an object just stored under key lives.
+func (cfg *apiConfig) storageLocation(key string) *database.StorageLocation {
+	loc := database.StorageLocation{Backend: cfg.storageBackend, Key: key}
+	if cfg.storageBackend == "s3" {
+		loc.Bucket = cfg.s3Bucket
+	}
+	return &loc
+}
+
+// inConfiguredStore reports whether loc is in the store the app writes to,
+// which is the only one it can delete from or presign for.
+func (cfg *apiConfig) inConfiguredStore(loc database.StorageLocation) bool {
+	if loc.Backend != cfg.storageBackend {
+		return false
+	}
+	return loc.Backend != "s3" || loc.Bucket == cfg.s3Bucket
+}
+
+// locationURL returns the URL clients fetch the object at loc from, for the
+// request being served.
+func (cfg *apiConfig) locationURL(r *http.Request, loc database.StorageLocation) (string, error) {
+	switch loc.Backend {
+	case "local":
+		return requestBaseURL(r) + "/assets/" + loc.Key, nil
+	case "s3":
+		switch cfg.mediaOpts.Mode {
+		case mediaURLPublic:
+			return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", loc.Bucket, cfg.s3Region, loc.Key), nil
+		case mediaURLPresigned:
+			if !cfg.inConfiguredStore(loc) {
+				return "", fmt.Errorf("can't presign objects in bucket %q", loc.Bucket)
+			}
+			return cfg.store.Presign(r.Context(), loc.Key, cfg.mediaOpts.TTL)
+		}
+		url := cfg.cdnURL(loc.Key)
+		if cfg.cdnSigner == nil {
+			return url, nil
+		}
+		return cfg.cdnSigner.SignURL(url, time.Now().Add(cfg.mediaOpts.TTL), cfg.signingClientIP(r))
+	}
+	return "", fmt.Errorf("unknown storage backend %q", loc.Backend)
+}
+
+func (cfg *apiConfig) cdnURL(key string) string {
+	return fmt.Sprintf("https://%s/%s", cfg.cloudFrontDomain, key)
+}
+
+// requestBaseURL is the scheme and host the request was made to, which the
+// app's own URLs are built from.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// presentVideo returns video with URLs for its stored media filled in.
+func (cfg *apiConfig) presentVideo(r *http.Request, video database.Video) (database.Video, error) {
+	assets := []struct {
+		loc *database.StorageLocation
+		url **string
+	}{
+		{video.VideoLocation, &video.VideoURL},
+		{video.HLSLocation, &video.HLSURL},
+		{video.ThumbnailLocation, &video.ThumbnailURL},
+	}
+	for _, asset := range assets {
+		if asset.loc == nil {
+			continue
+		}
+		url, err := cfg.locationURL(r, *asset.loc)
+		if err != nil {
+			return database.Video{}, err
+		}
+		*asset.url = &url
+	}
+	return video, nil
+}
+
+func (cfg *apiConfig) presentVideos(r *http.Request, videos []database.Video) ([]database.Video, error) {
+	presented := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		video, err := cfg.presentVideo(r, video)
+		if err != nil {
+			return nil, err
+		}
+		presented = append(presented, video)
+	}
+	return presented, nil
+}
+
+// setHLSCookies sets signed cookies that let the browser fetch every file of
+// the video's HLS rendition, which a signed playlist URL alone can't do: the
+// playlist refers to its segments by relative, unsigned URLs.
+func (cfg *apiConfig) setHLSCookies(w http.ResponseWriter, r *http.Request, video database.Video) error {
+	if cfg.cdnSigner == nil || cfg.mediaOpts.Mode != mediaURLCDN || cfg.mediaOpts.CookieDomain == "" {
+		return nil
+	}
+	if video.HLSLocation == nil || video.HLSLocation.Backend != "s3" {
+		return nil
+	}
+
+	dir := path.Dir(video.HLSLocation.Key) + "/"
+	cookies, err := cfg.cdnSigner.SignedCookies(cfg.cdnURL(dir+"*"), time.Now().Add(cfg.mediaOpts.TTL), cfg.signingClientIP(r))
+	if err != nil {
+		return err
+	}
+	for _, cookie := range cookies {
+		// Scoped to the directory, so cookies for several videos coexist
+		cookie.Domain = cfg.mediaOpts.CookieDomain
+		cookie.Path = "/" + dir
+		http.SetCookie(w, cookie)
+	}
+	return nil
+}
+
+// signingClientIP is the address signatures are restricted to, or "" when
+// they aren't.
+func (cfg *apiConfig) signingClientIP(r *http.Request) string {
+	if !cfg.mediaOpts.RestrictIP {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// assignStorageLocations completes locations converted from media URLs when
+// locations were first stored, which couldn't know the configured store.
+func (cfg *apiConfig) assignStorageLocations() error {
+	bucket := ""
+	if cfg.storageBackend == "s3" {
+		bucket = cfg.s3Bucket
+	}
+	n, err := cfg.db.AssignStorageLocations(cfg.storageBackend, bucket)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Assigned storage locations to %d media assets", n)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// quotaOptions are how much users may store. The configured ones apply to
+// every user an admin hasn't set their own for. Zero means no limit.
+type quotaOptions struct {
+	// Bytes caps the summed size of a user's uploads.
+	Bytes int64
+	// Videos caps how many videos a user has.
+	Videos int64
+}
+
+func quotaOptionsFromEnv() (quotaOptions, error) {
+	opts := quotaOptions{
+		Bytes:  10 << 30,
+		Videos: 100,
+	}
+	if v := os.Getenv("QUOTA_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return quotaOptions{}, fmt.Errorf("invalid QUOTA_BYTES %q", v)
+		}
+		opts.Bytes = n
+	}
+	if v := os.Getenv("QUOTA_VIDEOS"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return quotaOptions{}, fmt.Errorf("invalid QUOTA_VIDEOS %q", v)
+		}
+		opts.Videos = n
+	}
+	return opts, nil
+}
+
+// userQuota is the quota that applies to user.
+func (cfg *apiConfig) userQuota(user database.User) quotaOptions {
+	quota := cfg.quotaOpts
+	if user.QuotaBytes != nil {
+		quota.Bytes = *user.QuotaBytes
+	}
+	if user.QuotaVideos != nil {
+		quota.Videos = *user.QuotaVideos
+	}
+	return quota
+}
+
+// storageUsage is a user's usage along with the quotas that apply to them,
+// which are null when there's no limit.
+type storageUsage struct {
+	database.Usage
+	QuotaBytes  *int64 `json:"quota_bytes"`
+	QuotaVideos *int64 `json:"quota_videos"`
+}
+
+func (cfg *apiConfig) getStorageUsage(userID uuid.UUID) (storageUsage, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return storageUsage{}, err
+	}
+	if user == nil {
+		return storageUsage{}, errUserGone
+	}
+	usage, err := cfg.db.GetUserUsage(userID, time.Now().Add(-cfg.uploadExpiry))
+	if err != nil {
+		return storageUsage{}, err
+	}
+
+	quota := cfg.userQuota(*user)
+	result := storageUsage{Usage: usage}
+	if quota.Bytes > 0 {
+		result.QuotaBytes = &quota.Bytes
+	}
+	if quota.Videos > 0 {
+		result.QuotaVideos = &quota.Videos
+	}
+	return result, nil
+}
+
+// checkVideoQuota responds with 507 and returns false when the user already
+// has as many videos as they may.
+func (cfg *apiConfig) checkVideoQuota(w http.ResponseWriter, userID uuid.UUID) bool {
+	usage, err := cfg.getStorageUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return false
+	}
+	if usage.QuotaVideos != nil && usage.Videos >= *usage.QuotaVideos {
+		msg := fmt.Sprintf("Video quota exceeded: %d of %d videos used", usage.Videos, *usage.QuotaVideos)
+		respondWithError(w, http.StatusInsufficientStorage, msg, nil)
+		return false
+	}
+	return true
+}
+
+// checkUploadQuota checks that an upload of size bytes to video fits in its
+// owner's quota, counting uploads still in progress that haven't expired but
+// not what the upload replaces. It responds with 413 when the upload is
+// larger than the whole quota, or 507 when there isn't enough of it left, and
+// returns false.
+func (cfg *apiConfig) checkUploadQuota(w http.ResponseWriter, video database.Video, size int64) bool {
+	usage, err := cfg.getStorageUsage(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return false
+	}
+	if usage.QuotaBytes == nil {
+		return true
+	}
+
+	quota := *usage.QuotaBytes
+	if size > quota {
+		msg := fmt.Sprintf("Upload of %d bytes is larger than the storage quota of %d bytes", size, quota)
+		respondWithError(w, http.StatusRequestEntityTooLarge, msg, nil)
+		return false
+	}
+	used := usage.Bytes + usage.PendingBytes - video.StoredBytes
+	if used+size > quota {
+		msg := fmt.Sprintf("Upload of %d bytes would exceed the storage quota: %d of %d bytes used", size, used, quota)
+		respondWithError(w, http.StatusInsufficientStorage, msg, nil)
+		return false
+	}
+	return true
+}
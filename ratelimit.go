@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// rateLimitOptions are the limits of each group of routes, per client.
+type rateLimitOptions struct {
+	// Store is where buckets are kept: "memory", or "database" to share
+	// them between instances of the app.
+	Store string
+	// Auth limits logins, sign ups and token refreshes per IP address.
+	Auth ratelimit.Limit
+	// Public limits the routes that don't need credentials per IP address.
+	Public ratelimit.Limit
+	// APIByIP limits authenticated routes per IP address before credentials
+	// are checked, so bad ones can't be tried without limit.
+	APIByIP ratelimit.Limit
+	// API limits authenticated routes per user or API key.
+	API ratelimit.Limit
+	// Upload limits starting uploads per user or API key, on top of API.
+	Upload ratelimit.Limit
+	// FailOpen lets requests through when the store can't be reached,
+	// rather than failing them. Routes that check credentials always fail
+	// closed.
+	FailOpen bool
+}
+
+func rateLimitOptionsFromEnv() (rateLimitOptions, error) {
+	opts := rateLimitOptions{Store: os.Getenv("RATE_LIMIT_STORE"), FailOpen: true}
+	switch opts.Store {
+	case "":
+		opts.Store = "memory"
+	case "memory", "database":
+	default:
+		return rateLimitOptions{}, fmt.Errorf("unknown RATE_LIMIT_STORE %q, expected \"memory\" or \"database\"", opts.Store)
+	}
+
+	limits := []struct {
+		env   string
+		value string
+		dest  *ratelimit.Limit
+	}{
+		{"RATE_LIMIT_AUTH", "10/1m", &opts.Auth},
+		{"RATE_LIMIT_PUBLIC", "120/1m", &opts.Public},
+		{"RATE_LIMIT_API_IP", "1200/1m", &opts.APIByIP},
+		{"RATE_LIMIT_API", "600/1m", &opts.API},
+		{"RATE_LIMIT_UPLOAD", "30/10m", &opts.Upload},
+	}
+	for _, l := range limits {
+		value := l.value
+		if v := os.Getenv(l.env); v != "" {
+			value = v
+		}
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return rateLimitOptions{}, fmt.Errorf("invalid %s: %w", l.env, err)
+		}
+		*l.dest = limit
+	}
+	if v := os.Getenv("RATE_LIMIT_FAIL_OPEN"); v != "" {
+		failOpen, err := strconv.ParseBool(v)
+		if err != nil {
+			return rateLimitOptions{}, fmt.Errorf("invalid RATE_LIMIT_FAIL_OPEN %q", v)
+		}
+		opts.FailOpen = failOpen
+	}
+	return opts, nil
+}
+
+// rateLimitKey names the client a request counts against.
+type rateLimitKey func(r *http.Request) string
+
+func rateLimitByIP(r *http.Request) string {
+	return "ip:" + ratelimit.ClientIP(r)
+}
+
+// rateLimitByPrincipal counts requests made with an API key against the key
+// and other authenticated requests against the user, so one key can't use
+// up its owner's requests. Anonymous requests count against their address.
+func rateLimitByPrincipal(r *http.Request) string {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		return rateLimitByIP(r)
+	}
+	if principal.IsAPIKey() {
+		return "key:" + principal.APIKeyID.String()
+	}
+	return "user:" + principal.UserID.String()
+}
+
+// rateLimit lets each client make limit's requests to the routes of a group,
+// and answers the rest with 429. Groups are named so that their buckets are
+// kept apart. When the store can't be reached, requests are let through if
+// failOpen is set and answered with 503 otherwise.
+func (cfg *apiConfig) rateLimit(group string, limit ratelimit.Limit, key rateLimitKey, failOpen bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit.Unlimited() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := cfg.rateLimiter.Take(r.Context(), group+":"+key(r), limit)
+			if err != nil {
+				if !failOpen {
+					respondWithError(w, http.StatusServiceUnavailable, "Couldn't check rate limit, try again later", err)
+					return
+				}
+				log.Printf("Couldn't check %s rate limit, letting the request through: %v", group, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			result.SetHeaders(w.Header())
+			if !result.Allowed {
+				respondWithError(w, http.StatusTooManyRequests, "Too many requests, try again later", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
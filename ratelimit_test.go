@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// failingStore is a rate limit store that can't be reached.
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store is down")
+}
+
+func rateLimitedHandler(cfg *apiConfig, limit ratelimit.Limit, failOpen bool) http.Handler {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return cfg.rateLimit("test", limit, rateLimitByIP, failOpen)(ok)
+}
+
+func requestFrom(handler http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := &apiConfig{rateLimiter: ratelimit.NewMemoryStore()}
+	handler := rateLimitedHandler(cfg, ratelimit.Limit{Burst: 2, Period: time.Minute}, false)
+
+	for i, remaining := range []string{"1", "0"} {
+		rec := requestFrom(handler, "192.0.2.1:1234")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i+1, rec.Code)
+		}
+		if rec.Header().Get("RateLimit-Limit") != "2" || rec.Header().Get("RateLimit-Remaining") != remaining {
+			t.Fatalf("request %d: unexpected headers %v", i+1, rec.Header())
+		}
+	}
+
+	rec := requestFrom(handler, "192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: got %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "30" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("429: unexpected headers %v", rec.Header())
+	}
+
+	rec = requestFrom(handler, "192.0.2.2:1234")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("another address has its own bucket: got %d", rec.Code)
+	}
+}
+
+func TestRateLimitUnreachableStore(t *testing.T) {
+	cfg := &apiConfig{rateLimiter: failingStore{}}
+	limit := ratelimit.Limit{Burst: 2, Period: time.Minute}
+
+	rec := requestFrom(rateLimitedHandler(cfg, limit, true), "192.0.2.1:1234")
+	if rec.Code != http.StatusOK {
+		t.Errorf("fail open: got %d, want 200", rec.Code)
+	}
+	rec = requestFrom(rateLimitedHandler(cfg, limit, false), "192.0.2.1:1234")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("fail closed: got %d, want 503", rec.Code)
+	}
+}
+
+func TestRateLimitUnlimited(t *testing.T) {
+	cfg := &apiConfig{rateLimiter: failingStore{}}
+	rec := requestFrom(rateLimitedHandler(cfg, ratelimit.Limit{}, false), "192.0.2.1:1234")
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("unlimited group shouldn't touch the store: got %d, %v", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// signingKeyLead is how long a new key is published before it signs
+	// anything, so verifiers that cache the JWKS for jwksMaxAge already
+	// trust it by the time tokens signed with it show up.
+	signingKeyLead = 10 * time.Minute
+	jwksMaxAge     = 5 * time.Minute
+	// signingKeyRetention is how long past the expiry of the last token it
+	// could have signed a retired key stays published, to allow for clock
+	// skew between services.
+	signingKeyRetention    = time.Minute
+	signingKeyPollInterval = time.Minute
+)
+
+type signingKeyOptions struct {
+	Algorithm string
+	// Rotation is how long each key signs tokens before its successor takes
+	// over.
+	Rotation time.Duration
+}
+
+// startSigningKeyRotation loads the signing keys, creating the first one if
+// needed, and keeps them rotating until ctx is cancelled.
+func (cfg *apiConfig) startSigningKeyRotation(ctx context.Context) error {
+	err := cfg.refreshSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(signingKeyPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := cfg.refreshSigningKeys()
+				if err != nil {
+					log.Printf("Couldn't refresh signing keys: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// refreshSigningKeys creates the next key when the current one is due for
+// rotation, deletes keys none of whose tokens can still be valid, and
+// publishes the rest. Keys live in the database so that every instance signs
+// with and accepts the same ones.
+func (cfg *apiConfig) refreshSigningKeys() error {
+	now := time.Now().UTC()
+	rows, err := cfg.db.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 || cfg.signingKeyDue(rows[len(rows)-1], now) {
+		// The very first key can sign straight away: nobody holds tokens
+		// that would need verifying against an older one.
+		activatesAt := now
+		if len(rows) > 0 {
+			activatesAt = now.Add(signingKeyLead)
+		}
+		row, err := cfg.createSigningKey(activatesAt)
+		if err != nil {
+			return err
+		}
+		log.Printf("Created %s signing key %s, active from %s", row.Algorithm, row.ID, row.ActivatesAt.Format(time.RFC3339))
+		rows = append(rows, row)
+	}
+
+	// Keys are ordered by activation, so the signing key is the last one
+	// that has activated. Before any has, the earliest signs.
+	current := 0
+	for i, row := range rows {
+		if !row.ActivatesAt.After(now) {
+			current = i
+		}
+	}
+
+	var published []auth.SigningKey
+	var signing auth.SigningKey
+	for i, row := range rows {
+		if i < current {
+			// Tokens signed with a retired key were all issued before its
+			// successor activated.
+			lastValid := rows[i+1].ActivatesAt.Add(accessTokenTTL + signingKeyRetention)
+			if now.After(lastValid) {
+				err := cfg.db.DeleteSigningKey(row.ID)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		key, err := auth.ParseSigningKey(row.ID, row.Algorithm, row.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("couldn't parse signing key %s: %w", row.ID, err)
+		}
+		if i == current {
+			signing = key
+		}
+		published = append(published, key)
+	}
+
+	cfg.signingKeys.Replace(signing, published)
+	return nil
+}
+
+// signingKeyDue reports whether newest, the most recently created key, should
+// get a successor. Successors are created a lead time ahead of when they
+// take over, and straight away when the configured algorithm has changed.
+func (cfg *apiConfig) signingKeyDue(newest database.SigningKey, now time.Time) bool {
+	if newest.Algorithm != cfg.signingKeyOpts.Algorithm {
+		return true
+	}
+	return !now.Before(newest.ActivatesAt.Add(cfg.signingKeyOpts.Rotation - signingKeyLead))
+}
+
+func (cfg *apiConfig) createSigningKey(activatesAt time.Time) (database.SigningKey, error) {
+	key, err := auth.GenerateSigningKey(cfg.signingKeyOpts.Algorithm)
+	if err != nil {
+		return database.SigningKey{}, err
+	}
+	privatePEM, err := key.MarshalPrivateKey()
+	if err != nil {
+		return database.SigningKey{}, err
+	}
+
+	params := database.CreateSigningKeyParams{
+		ID:          key.ID,
+		ActivatesAt: activatesAt,
+		Algorithm:   key.Algorithm,
+		PrivateKey:  privatePEM,
+	}
+	err = cfg.db.CreateSigningKey(params)
+	if err != nil {
+		return database.SigningKey{}, err
+	}
+	return database.SigningKey{CreatedAt: time.Now().UTC(), CreateSigningKeyParams: params}, nil
+}
+
+// handlerJWKS publishes the public keys access tokens can be verified with.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	respondWithJSON(w, http.StatusOK, cfg.signingKeys.JWKS())
+}
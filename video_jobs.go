@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxJobAttempts  = 3
+	jobPollInterval = 5 * time.Second
+	// jobRetryBase is how long a failed job waits before its first retry.
+	// Each later retry waits twice as long, up to jobRetryMax.
+	jobRetryBase = 30 * time.Second
+	jobRetryMax  = 10 * time.Minute
+)
+
+var errVideoGone = errors.New("video no longer exists")
+
+// startVideoWorkers requeues jobs interrupted by a previous shutdown and
+// starts n workers that process uploaded videos until ctx is cancelled.
+func (cfg *apiConfig) startVideoWorkers(ctx context.Context, n int) error {
+	requeued, err := cfg.db.RequeueRunningJobs()
+	if err != nil {
+		return err
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d interrupted video jobs", requeued)
+	}
+
+	for i := 0; i < n; i++ {
+		go cfg.runVideoWorker(ctx)
+	}
+	return nil
+}
+
+// enqueueVideoJob records a durable processing job for an uploaded file of
+// size bytes, charges them to the video's owner and wakes a worker to pick
+// the job up.
+func (cfg *apiConfig) enqueueVideoJob(params database.CreateJobParams, size int64) error {
+	_, err := cfg.db.CreateJob(params)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.SetVideoStoredBytes(params.VideoID, size)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.SetVideoProcessingState(params.VideoID, database.ProcessingStateUploaded, nil)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case cfg.jobWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (cfg *apiConfig) runVideoWorker(ctx context.Context) {
+	for {
+		job, err := cfg.db.ClaimJob()
+		if err != nil {
+			log.Printf("Couldn't claim video job: %v", err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-cfg.jobWake:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+		cfg.runVideoJob(ctx, *job)
+	}
+}
+
+func (cfg *apiConfig) runVideoJob(ctx context.Context, job database.Job) {
+	log.Printf("Processing video %s (job %s, attempt %d)", job.VideoID, job.ID, job.Attempts)
+
+	err := cfg.db.SetVideoProcessingState(job.VideoID, database.ProcessingStateProcessing, nil)
+	if err != nil {
+		log.Printf("Couldn't update processing state for video %s: %v", job.VideoID, err)
+	}
+
+	err = cfg.processVideoJob(ctx, job)
+	if err == nil || errors.Is(err, errVideoGone) {
+		if err != nil {
+			log.Printf("Dropping job %s: %v", job.ID, err)
+		}
+		if err := cfg.db.CompleteJob(job.ID); err != nil {
+			log.Printf("Couldn't complete job %s: %v", job.ID, err)
+		}
+		cfg.removeJobSource(ctx, job)
+		return
+	}
+
+	log.Printf("Video job %s failed: %v", job.ID, err)
+	retry := job.Attempts < maxJobAttempts
+	var retryAt *time.Time
+	if retry {
+		t := time.Now().Add(jobRetryDelay(job.Attempts))
+		retryAt = &t
+	}
+	if err := cfg.db.FailJob(job.ID, err, retryAt); err != nil {
+		log.Printf("Couldn't record failure of job %s: %v", job.ID, err)
+	}
+	if retry {
+		err = cfg.db.SetVideoProcessingState(job.VideoID, database.ProcessingStateUploaded, nil)
+	} else {
+		msg := err.Error()
+		err = cfg.db.SetVideoProcessingState(job.VideoID, database.ProcessingStateFailed, &msg)
+		cfg.removeJobSource(ctx, job)
+	}
+	if err != nil {
+		log.Printf("Couldn't update processing state for video %s: %v", job.VideoID, err)
+	}
+}
+
+// jobRetryDelay is how long to wait before retrying a job that has failed
+// attempts times.
+func jobRetryDelay(attempts int) time.Duration {
+	delay := jobRetryBase
+	for i := 1; i < attempts && delay < jobRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, jobRetryMax)
+}
+
+// processVideoJob points the job's video at the processed media for its
+// source file. Media already processed from a file with the same contents is
+// reused; otherwise the fast-start, aspect ratio and HLS pipeline is run and
+// its results are stored as a new blob.
+func (cfg *apiConfig) processVideoJob(ctx context.Context, job database.Job) error {
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return errVideoGone
+	}
+
+	sourcePath := job.SourcePath
+	sourceSHA256 := job.SourceSHA256
+	if job.SourceKey != "" {
+		sourcePath, sourceSHA256, err = cfg.downloadJobSource(ctx, job.SourceKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(sourcePath)
+	}
+	if sourceSHA256 == "" {
+		sourceSHA256, err = hashFile(sourcePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	blob, err := cfg.db.GetBlob(sourceSHA256)
+	if err != nil {
+		return fmt.Errorf("couldn't get blob: %w", err)
+	}
+	if blob.SHA256 != "" && cfg.inConfiguredStore(database.StorageLocation{Backend: blob.Backend, Bucket: blob.Bucket}) {
+		metadata, ok, err := cfg.db.GetBlobMetadata(blob.SHA256)
+		if err != nil {
+			return fmt.Errorf("couldn't get blob metadata: %w", err)
+		}
+		if ok {
+			log.Printf("Reusing processed media %s for video %s", blob.SHA256, video.ID)
+			return cfg.attachBlob(ctx, job.VideoID, blob, metadata)
+		}
+	}
+
+	blob, metadata, err := cfg.processSource(ctx, sourcePath, sourceSHA256, job.ContentType)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreateBlob(blob.CreateBlobParams)
+	if err != nil {
+		return fmt.Errorf("couldn't save blob: %w", err)
+	}
+	return cfg.attachBlob(ctx, job.VideoID, blob, metadata)
+}
+
+// processSource runs the fast-start, aspect ratio and HLS pipeline for the
+// source file with the given hash and stores the results under keys derived
+// from it.
+func (cfg *apiConfig) processSource(ctx context.Context, sourcePath, sourceSHA256, contentType string) (database.Blob, database.MediaMetadata, error) {
+	processedPath, err := processVideoForFastStart(sourcePath)
+	if err != nil {
+		return database.Blob{}, database.MediaMetadata{}, err
+	}
+	defer os.Remove(processedPath)
+
+	probe, err := probeVideo(processedPath)
+	if err != nil {
+		return database.Blob{}, database.MediaMetadata{}, fmt.Errorf("failed to probe video: %w", err)
+	}
+	if _, _, ok := probe.videoSize(); !ok {
+		return database.Blob{}, database.MediaMetadata{}, errors.New("no video streams found")
+	}
+	metadata := probe.metadata()
+	prefix := metadata.AspectRatio
+
+	loc := cfg.storageLocation("")
+	blob := database.Blob{CreateBlobParams: database.CreateBlobParams{
+		SHA256:   sourceSHA256,
+		Backend:  loc.Backend,
+		Bucket:   loc.Bucket,
+		VideoKey: fmt.Sprintf("%s/%s.mp4", prefix, sourceSHA256),
+	}}
+
+	processedFile, err := os.Open(processedPath)
+	if err != nil {
+		return database.Blob{}, database.MediaMetadata{}, fmt.Errorf("failed to open processed file: %w", err)
+	}
+	defer processedFile.Close()
+
+	err = cfg.store.Put(ctx, blob.VideoKey, processedFile, contentType)
+	if err != nil {
+		return database.Blob{}, database.MediaMetadata{}, fmt.Errorf("failed to store video: %w", err)
+	}
+
+	hlsDir, err := os.MkdirTemp("", "tubely-hls-*")
+	if err != nil {
+		return database.Blob{}, database.MediaMetadata{}, fmt.Errorf("couldn't create HLS directory: %w", err)
+	}
+	defer os.RemoveAll(hlsDir)
+
+	masterPath, err := processVideoForHLS(processedPath, hlsDir)
+	if err != nil {
+		return database.Blob{}, database.MediaMetadata{}, err
+	}
+
+	hlsPrefix := fmt.Sprintf("%s/%s/hls", prefix, sourceSHA256)
+	err = cfg.putDirectory(ctx, hlsDir, hlsPrefix, hlsContentType)
+	if err != nil {
+		return database.Blob{}, database.MediaMetadata{}, fmt.Errorf("failed to store HLS renditions: %w", err)
+	}
+	blob.HLSKey = hlsPrefix + "/" + filepath.Base(masterPath)
+
+	// Thumbnails are made even when this video has one of its own, for later
+	// videos that reuse the blob. A missing thumbnail isn't worth failing the
+	// whole job over.
+	thumbnailKey, err := cfg.storeAutoThumbnails(ctx, processedPath, fmt.Sprintf("%s/%s/thumbnails", prefix, sourceSHA256))
+	if err != nil {
+		log.Printf("Couldn't generate thumbnails for %s: %v", sourceSHA256, err)
+	} else {
+		blob.ThumbnailKey = thumbnailKey
+	}
+	return blob, metadata, nil
+}
+
+// attachBlob points a video at a blob's media and marks it ready.
+func (cfg *apiConfig) attachBlob(ctx context.Context, videoID uuid.UUID, blob database.Blob, metadata database.MediaMetadata) error {
+	// Re-read the video so metadata edits made while processing survive
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return errVideoGone
+	}
+
+	// The media being replaced. Its thumbnail stays, as the new video only
+	// gets one when there is none.
+	replaced := video
+	replaced.ThumbnailLocation = nil
+
+	// Taking a reference first fails when the blob has just been released,
+	// rather than pointing the video at objects on their way out
+	released, err := cfg.db.SetVideoBlob(video.ID, blob.SHA256)
+	if err != nil {
+		return fmt.Errorf("couldn't update blob references: %w", err)
+	}
+
+	// Videos point at storage locations; URLs are made, and signed, when
+	// they're served
+	media := blobVideo(blob)
+	video.VideoURL = nil
+	video.VideoLocation = media.VideoLocation
+	video.HLSURL = nil
+	video.HLSLocation = media.HLSLocation
+
+	// Only fill in a thumbnail when the user hasn't uploaded one
+	if video.ThumbnailURL == nil && video.ThumbnailLocation == nil {
+		video.ThumbnailLocation = media.ThumbnailLocation
+	}
+
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		return fmt.Errorf("couldn't update video: %w", err)
+	}
+	cfg.discardReplacedMedia(ctx, replaced, released)
+	err = cfg.db.SetVideoMetadata(video.ID, metadata)
+	if err != nil {
+		return fmt.Errorf("couldn't save video metadata: %w", err)
+	}
+	return cfg.db.SetVideoProcessingState(video.ID, database.ProcessingStateReady, nil)
+}
+
+// storeAutoThumbnails extracts thumbnails from the video at filePath, stores
+// every size under keyPrefix and returns the key of the largest one.
+func (cfg *apiConfig) storeAutoThumbnails(ctx context.Context, filePath, keyPrefix string) (string, error) {
+	thumbnailDir, err := os.MkdirTemp("", "tubely-thumbnails-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(thumbnailDir)
+
+	paths, err := extractThumbnails(filePath, thumbnailDir, cfg.thumbnailOpts)
+	if err != nil {
+		return "", err
+	}
+
+	keys := []string{}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		key := keyPrefix + "/" + filepath.Base(p)
+		err = cfg.store.Put(ctx, key, f, "image/jpeg")
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to store thumbnail: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys[0], nil
+}
+
+// downloadJobSource copies a staged upload out of the blob store so ffmpeg
+// can work on a local file, and returns the file's path and hash.
+func (cfg *apiConfig) downloadJobSource(ctx context.Context, key string) (string, string, error) {
+	body, _, err := cfg.store.Get(ctx, key)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't get staged upload %s: %w", key, err)
+	}
+	defer body.Close()
+
+	sourceFile, err := os.CreateTemp(cfg.uploadsDir, "direct-*.mp4")
+	if err != nil {
+		return "", "", err
+	}
+	defer sourceFile.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(sourceFile, hasher), body)
+	if err != nil {
+		os.Remove(sourceFile.Name())
+		return "", "", fmt.Errorf("couldn't download staged upload %s: %w", key, err)
+	}
+	return sourceFile.Name(), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// removeJobSource deletes a job's input once it will not be retried.
+func (cfg *apiConfig) removeJobSource(ctx context.Context, job database.Job) {
+	if job.SourcePath != "" {
+		os.Remove(job.SourcePath)
+	}
+	if job.SourceKey != "" {
+		cfg.deleteObjects(ctx, []string{job.SourceKey})
+	}
+}
+
+// discardReplacedMedia queues the media of a video that has been replaced by
+// a new upload for deletion. released is the blob the video moving to the
+// new upload's blob let go of, if any.
+func (cfg *apiConfig) discardReplacedMedia(ctx context.Context, replaced database.Video, released *database.Blob) {
+	keys, err := cfg.unusedMediaKeys(ctx, replaced, released)
+	if err != nil {
+		log.Printf("Couldn't find every replaced object of video %s, the rest is left to garbage collection: %v", replaced.ID, err)
+	}
+	err = cfg.discardObjects(keys)
+	if err != nil {
+		log.Printf("Couldn't queue replaced media of video %s for deletion: %v", replaced.ID, err)
+	}
+}
@@ -1,69 +1,355 @@
 package main
 
 import (
-    "bytes"
-    "encoding/json"
-    "fmt"
-    "os/exec"
-    
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 type FFProbeOutput struct {
-    Streams []struct {
-        Width  int `json:"width"`
-        Height int `json:"height"`
-    } `json:"streams"`
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		Channels     int    `json:"channels"`
+		Tags         struct {
+			Rotate string `json:"rotate"`
+		} `json:"tags"`
+		SideDataList []struct {
+			Rotation int `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+		Size       string `json:"size"`
+	} `json:"format"`
+}
+
+func probeVideo(filePath string) (FFProbeOutput, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", "-show_format", filePath)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if err != nil {
+		return FFProbeOutput{}, err
+	}
+
+	var result FFProbeOutput
+	err = json.Unmarshal(out.Bytes(), &result)
+	if err != nil {
+		return FFProbeOutput{}, err
+	}
+	return result, nil
+}
+
+// videoSize returns the dimensions of the first video stream.
+func (p FFProbeOutput) videoSize() (int, int, bool) {
+	for _, stream := range p.Streams {
+		if stream.CodecType == "video" {
+			return stream.Width, stream.Height, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (p FFProbeOutput) hasAudio() bool {
+	for _, stream := range p.Streams {
+		if stream.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// metadata flattens the probe result into what we persist for a video.
+func (p FFProbeOutput) metadata() database.MediaMetadata {
+	meta := database.MediaMetadata{
+		Container: p.Format.FormatName,
+	}
+	meta.DurationSeconds, _ = strconv.ParseFloat(p.Format.Duration, 64)
+	meta.Bitrate, _ = strconv.ParseInt(p.Format.BitRate, 10, 64)
+	meta.FileSize, _ = strconv.ParseInt(p.Format.Size, 10, 64)
+
+	foundVideo, foundAudio := false, false
+	for _, stream := range p.Streams {
+		switch {
+		case stream.CodecType == "video" && !foundVideo:
+			foundVideo = true
+			meta.VideoCodec = stream.CodecName
+			meta.Width = stream.Width
+			meta.Height = stream.Height
+			meta.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if len(stream.SideDataList) > 0 && stream.SideDataList[0].Rotation != 0 {
+				meta.Rotation = stream.SideDataList[0].Rotation
+			} else if stream.Tags.Rotate != "" {
+				meta.Rotation, _ = strconv.Atoi(stream.Tags.Rotate)
+			}
+		case stream.CodecType == "audio" && !foundAudio:
+			foundAudio = true
+			meta.AudioCodec = stream.CodecName
+			meta.AudioChannels = stream.Channels
+		}
+	}
+	meta.AspectRatio = aspectRatioName(meta.Width, meta.Height, meta.Rotation)
+	return meta
+}
+
+// parseFrameRate turns ffprobe's rational frame rates like "30000/1001" into
+// frames per second.
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-    cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-    var out bytes.Buffer
-    cmd.Stdout = &out
-    err := cmd.Run()
-    if err != nil {
-        return "", err
-    }
-
-    var result FFProbeOutput
-    err = json.Unmarshal(out.Bytes(), &result)
-    if err != nil {
-        return "", err
-    }
-
-    if len(result.Streams) == 0 {
-        return "", fmt.Errorf("no video streams found")
-    }
-
-    width := result.Streams[0].Width
-    height := result.Streams[0].Height
-
-    if height == 0 {
-        return "other", nil
-    }
-    aspectRatio := float64(width) / float64(height)
-
-    target169 := 16.0 / 9.0
-    tolerance := 0.1
-
-    if aspectRatio >= target169-tolerance && aspectRatio <= target169+tolerance {
-        return "landscape", nil
-    } else if 1.0/aspectRatio >= target169-tolerance && 1.0/aspectRatio <= target169+tolerance {
-        return "portrait", nil
-    } else {
-        return "other", nil
-    }
+// aspectRatioName classifies a video as "landscape" (16:9), "portrait" (9:16)
+// or "other", taking display rotation into account.
+func aspectRatioName(width, height, rotation int) string {
+	if rotation%180 != 0 {
+		width, height = height, width
+	}
+	if height == 0 {
+		return "other"
+	}
+	aspectRatio := float64(width) / float64(height)
+
+	target169 := 16.0 / 9.0
+	tolerance := 0.1
+
+	if aspectRatio >= target169-tolerance && aspectRatio <= target169+tolerance {
+		return "landscape"
+	} else if 1.0/aspectRatio >= target169-tolerance && 1.0/aspectRatio <= target169+tolerance {
+		return "portrait"
+	} else {
+		return "other"
+	}
 }
 
+// processVideoForFastStart writes a copy of filePath with its index moved to
+// the front to a new temp file, and returns the temp file's path. Output of
+// an attempt cut short by a crash is never in the way of the next one.
 func processVideoForFastStart(filePath string) (string, error) {
-    // Create output file path with .processing suffix
-    outputPath := filePath + ".processing"
+	outputFile, err := os.CreateTemp("", "tubely-faststart-*.mp4")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create fast start output: %v", err)
+	}
+	outputPath := outputFile.Name()
+	outputFile.Close()
+
+	// -y, as ffmpeg won't write to the file just created otherwise
+	cmd := exec.Command("ffmpeg", "-y", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
+	err = cmd.Run()
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("failed to process video for fast start: %v", err)
+	}
+
+	return outputPath, nil
+}
+
+type hlsRendition struct {
+	Name         string
+	Resolution   int // short side in pixels, e.g. 720 for 1280x720
+	VideoBitrate string
+	AudioBitrate string
+}
 
-    // Run ffmpeg command for fast start encoding
-    cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
-    err := cmd.Run()
-    if err != nil {
-        return "", fmt.Errorf("failed to process video for fast start: %v", err)
-    }
+var hlsLadder = []hlsRendition{
+	{Name: "1080p", Resolution: 1080, VideoBitrate: "5000k", AudioBitrate: "192k"},
+	{Name: "720p", Resolution: 720, VideoBitrate: "2800k", AudioBitrate: "128k"},
+	{Name: "480p", Resolution: 480, VideoBitrate: "1400k", AudioBitrate: "128k"},
+	{Name: "360p", Resolution: 360, VideoBitrate: "800k", AudioBitrate: "96k"},
+}
+
+const hlsMasterPlaylist = "master.m3u8"
+
+// processVideoForHLS encodes filePath into an HLS ladder inside outputDir:
+// a master playlist plus one directory of segments per rendition. Renditions
+// larger than the source are skipped, but the smallest one is always kept.
+// It returns the path of the master playlist.
+func processVideoForHLS(filePath, outputDir string) (string, error) {
+	probe, err := probeVideo(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to probe video: %v", err)
+	}
+	width, height, ok := probe.videoSize()
+	if !ok {
+		return "", fmt.Errorf("no video streams found")
+	}
+	portrait := height > width
+	source := min(width, height)
+
+	renditions := []hlsRendition{}
+	for _, rendition := range hlsLadder {
+		if rendition.Resolution <= source {
+			renditions = append(renditions, rendition)
+		}
+	}
+	if len(renditions) == 0 {
+		renditions = hlsLadder[len(hlsLadder)-1:]
+	}
+
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(renditions))
+	for i := range renditions {
+		fmt.Fprintf(&filter, "[s%d]", i)
+	}
+	for i, rendition := range renditions {
+		scale := fmt.Sprintf("-2:%d", rendition.Resolution)
+		if portrait {
+			scale = fmt.Sprintf("%d:-2", rendition.Resolution)
+		}
+		fmt.Fprintf(&filter, ";[s%d]scale=%s[v%d]", i, scale, i)
+	}
+
+	args := []string{"-i", filePath, "-filter_complex", filter.String()}
+	streamMap := []string{}
+	for i, rendition := range renditions {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%d]", i),
+			fmt.Sprintf("-c:v:%d", i), "libx264",
+			fmt.Sprintf("-b:v:%d", i), rendition.VideoBitrate,
+		)
+		entry := fmt.Sprintf("v:%d", i)
+		if probe.hasAudio() {
+			args = append(args,
+				"-map", "0:a:0",
+				fmt.Sprintf("-c:a:%d", i), "aac",
+				fmt.Sprintf("-b:a:%d", i), rendition.AudioBitrate,
+			)
+			entry += fmt.Sprintf(",a:%d", i)
+		}
+		streamMap = append(streamMap, entry+",name:"+rendition.Name)
+	}
+	args = append(args,
+		"-preset", "veryfast",
+		// Keyframes every 2s at common frame rates so segments line up across renditions
+		"-g", "48", "-sc_threshold", "0",
+		"-f", "hls",
+		"-hls_time", "6",
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_filename", filepath.Join(outputDir, "%v", "segment_%03d.ts"),
+		"-master_pl_name", hlsMasterPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(outputDir, "%v", "index.m3u8"),
+	)
+
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("failed to package video for HLS: %v: %s", err, stderr.String())
+	}
+
+	return filepath.Join(outputDir, hlsMasterPlaylist), nil
+}
+
+// hlsContentType returns the MIME type for files produced by processVideoForHLS.
+func hlsContentType(fileName string) string {
+	switch filepath.Ext(fileName) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+type thumbnailOptions struct {
+	// Mode is "timestamp" to grab the frame at Timestamp, or "scene" to grab
+	// the first frame after a clear scene change.
+	Mode      string
+	Timestamp time.Duration
+}
+
+// thumbnailWidths are the sizes generated for automatic thumbnails, largest first.
+var thumbnailWidths = []int{1280, 640, 320}
+
+// extractThumbnails grabs a representative frame from filePath and writes it
+// to outputDir once per thumbnailWidths entry, as <width>.jpg. Sizes wider
+// than the source frame are skipped, except for the smallest. It returns the
+// written paths, largest first.
+func extractThumbnails(filePath, outputDir string, opts thumbnailOptions) ([]string, error) {
+	framePath := filepath.Join(outputDir, "frame.jpg")
+	if opts.Mode == "scene" {
+		cmd := exec.Command("ffmpeg", "-i", filePath,
+			"-vf", "select='gt(scene,0.4)'", "-frames:v", "1", "-fps_mode", "vfr", "-q:v", "2", framePath)
+		err := cmd.Run()
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract scene frame: %v", err)
+		}
+	} else {
+		err := extractFrameAt(filePath, framePath, opts.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A video without scene changes, or shorter than the timestamp, produces
+	// no frame at all, so fall back to the very first one.
+	if _, err := os.Stat(framePath); os.IsNotExist(err) {
+		err := extractFrameAt(filePath, framePath, 0)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(framePath); err != nil {
+			return nil, fmt.Errorf("failed to extract a frame: %v", err)
+		}
+	}
+
+	probe, err := probeVideo(framePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe frame: %v", err)
+	}
+	frameWidth, _, _ := probe.videoSize()
+
+	paths := []string{}
+	for i, width := range thumbnailWidths {
+		if width > frameWidth && i != len(thumbnailWidths)-1 {
+			continue
+		}
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("%d.jpg", width))
+		cmd := exec.Command("ffmpeg", "-i", framePath, "-vf", fmt.Sprintf("scale=%d:-2", width), "-q:v", "3", outputPath)
+		err := cmd.Run()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resize thumbnail to %dpx: %v", width, err)
+		}
+		paths = append(paths, outputPath)
+	}
+	return paths, nil
+}
 
-    return outputPath, nil
+func extractFrameAt(filePath, outputPath string, timestamp time.Duration) error {
+	seek := strconv.FormatFloat(timestamp.Seconds(), 'f', 3, 64)
+	cmd := exec.Command("ffmpeg", "-y", "-ss", seek, "-i", filePath, "-frames:v", "1", "-q:v", "2", outputPath)
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("failed to extract frame at %ss: %v", seek, err)
+	}
+	return nil
 }